@@ -3,7 +3,6 @@ package cli
 import (
 	"bufio"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/cloudcentricdev/golang-tutorials/07/db"
@@ -18,12 +17,13 @@ func NewCLI(s *bufio.Scanner, b *db.DB) *CLI {
 	return &CLI{s, b}
 }
 
+// Start processes commands until EXIT is entered or the input ends.
 func (c *CLI) Start() {
 	c.printHelp()
 	c.printPrompt()
-	for {
-		if c.scanner.Scan() {
-			c.processInput(c.scanner.Text())
+	for c.scanner.Scan() {
+		if !c.processInput(c.scanner.Text()) {
+			return
 		}
 	}
 }
@@ -44,11 +44,12 @@ func (c *CLI) printPrompt() {
 	fmt.Print("> ")
 }
 
-func (c *CLI) processInput(line string) {
+// processInput executes a single command and reports whether the session continues.
+func (c *CLI) processInput(line string) bool {
 	fields := strings.Fields(line)
 
 	if len(fields) < 1 {
-		return
+		return true
 	}
 	command := strings.ToLower(fields[0])
 
@@ -62,9 +63,10 @@ func (c *CLI) processInput(line string) {
 	case "get":
 		c.processGetCommand(fields[1:])
 	case "exit":
-		os.Exit(0)
+		return false
 	}
 	c.printPrompt()
+	return true
 }
 
 func (c *CLI) processSetCommand(args []string) {
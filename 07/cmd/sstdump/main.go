@@ -0,0 +1,300 @@
+// Command sstdump prints the contents of *.sst files: the footer, the properties, the index block entries, and every
+// data block with its chunk offsets and decoded entries. With -verify, it walks each file instead and reports any
+// structural damage it finds.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/sstable"
+)
+
+var (
+	format      = flag.String("format", "escaped", `Output format of keys and values: "escaped" or "hex".`)
+	start       = flag.String("start", "", "Only print entries whose user key is greater than or equal to this key.")
+	end         = flag.String("end", "", "Only print entries whose user key is smaller than this key.")
+	shouldCheck = flag.Bool("verify", false, "Walk every block of the files and report structural errors instead of printing them.")
+)
+
+// compareKeys orders keys by the default comparer, which is the only one available to the tool
+var compareKeys = encoder.KeyComparer(comparer.Default.Compare)
+
+// knownComparer reports whether the keys of the table read by "r" are ordered by the default comparer. A table that
+// doesn't record the name of its comparer is treated as if it used an unknown one.
+func knownComparer(r *sstable.Reader) bool {
+	return r.Properties().ComparerName == comparer.Default.Name()
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), "\nSSTable Dump\n\nUsage:\n  sstdump [flags] <file.sst>...\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || (*format != "escaped" && *format != "hex") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		var err error
+		if *shouldCheck {
+			err = verify(path)
+		} else {
+			err = dump(path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func openTable(path string) (*sstable.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// the file number only serves to identify the file in error messages
+	var fileNum int
+	fmt.Sscanf(filepath.Base(path), "%06d.sst", &fileNum)
+	r, err := sstable.NewReader(f, sstable.ReaderOptions{FileNum: fileNum})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func dump(path string) error {
+	r, err := openTable(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	layout, err := r.Layout()
+	if err != nil {
+		return err
+	}
+	if (*start != "" || *end != "") && !knownComparer(r) {
+		return fmt.Errorf("cannot filter by key range, keys are ordered by unknown comparer %q", r.Properties().ComparerName)
+	}
+
+	fmt.Printf("%s (%d bytes)\n", path, r.Size())
+	fmt.Println("footer:")
+	fmt.Printf("  version:    %d\n", layout.Version)
+	fmt.Printf("  offset:     %d\n", layout.Footer)
+	fmt.Printf("  filter:     %s\n", formatHandle(layout.Filter))
+	fmt.Printf("  index:      %s\n", formatHandle(layout.Index))
+	fmt.Printf("  properties: %s\n", formatHandle(layout.Properties))
+
+	p := r.Properties()
+	fmt.Println("properties:")
+	fmt.Printf("  entries:         %d\n", p.NumEntries)
+	fmt.Printf("  tombstones:      %d\n", p.NumTombstones)
+	fmt.Printf("  raw key size:    %d\n", p.RawKeySize)
+	fmt.Printf("  raw value size:  %d\n", p.RawValueSize)
+	fmt.Printf("  data size:       %d\n", p.DataSize)
+	fmt.Printf("  data blocks:     %d\n", p.NumDataBlocks)
+	fmt.Printf("  smallest key:    %s\n", formatKey(p.SmallestKey))
+	fmt.Printf("  largest key:     %s\n", formatKey(p.LargestKey))
+	fmt.Printf("  compression:     %s\n", p.Compression)
+	fmt.Printf("  creation time:   %s\n", p.CreationTime)
+	fmt.Printf("  comparer:        %s\n", p.ComparerName)
+
+	fmt.Printf("index: %d entries\n", len(layout.Data))
+	for i, e := range layout.Data {
+		fmt.Printf("  #%d %s -> %s\n", i, formatKey(e.Key), formatHandle(e.Handle))
+	}
+
+	for i, e := range layout.Data {
+		// the index key sorts after every key of its data block, so the block can be skipped if it precedes "start"
+		if *start != "" && comparer.Default.Compare(userKey(e.Key), []byte(*start)) < 0 {
+			continue
+		}
+		block, err := r.ReadDataBlock(e.Handle)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("data block #%d: %s, %s, %d bytes decompressed\n", i, formatHandle(e.Handle), block.Compression, block.Size)
+		fmt.Printf("  chunk offsets: %v\n", block.ChunkOffsets)
+		for _, entry := range block.Entries {
+			if !inRange(entry.Key) {
+				continue
+			}
+			fmt.Printf("  %s %s\n", formatKey(entry.Key), formatValue(entry.Value))
+		}
+		if *end != "" && len(block.Entries) > 0 && !beforeEnd(block.Entries[len(block.Entries)-1].Key) {
+			break
+		}
+	}
+	return nil
+}
+
+// verify walks every block of the *.sst file and reports all structural errors found in the data blocks, or the
+// first error preventing the file from being opened at all.
+func verify(path string) error {
+	r, err := openTable(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	layout, err := r.Layout()
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	checkOrder := knownComparer(r)
+	if !checkOrder {
+		fmt.Printf("%s: key order not checked, keys are ordered by unknown comparer %q\n", path, r.Properties().ComparerName)
+	}
+	var firstKey, prevKey, prevIndexKey []byte
+	var nextOffset uint32
+	var numEntries, numTombstones uint64
+	for i, e := range layout.Data {
+		if !validKey(e.Key) {
+			report("index entry #%d: key %x is too short", i, e.Key)
+			continue
+		}
+		if checkOrder && prevIndexKey != nil && compareKeys(prevIndexKey, e.Key) >= 0 {
+			report("index entry #%d: key %s does not sort after %s", i, formatKey(e.Key), formatKey(prevIndexKey))
+		}
+		lowerBound := prevIndexKey // every key of the data block must sort after the previous index key
+		prevIndexKey = e.Key
+		if e.Handle.Offset != nextOffset {
+			report("data block #%d: starts at offset %d instead of %d", i, e.Handle.Offset, nextOffset)
+		}
+		nextOffset = e.Handle.Offset + e.Handle.Length + sstable.BlockTrailerSize
+
+		block, err := r.ReadDataBlock(e.Handle)
+		if err != nil {
+			report("data block #%d: %v", i, err)
+			continue
+		}
+		if len(block.Entries) == 0 {
+			report("data block #%d: empty", i)
+		}
+		for j, entry := range block.Entries {
+			if !validKey(entry.Key) {
+				report("data block #%d, entry #%d: key %x is too short", i, j, entry.Key)
+				continue
+			}
+			if checkOrder && prevKey != nil && compareKeys(prevKey, entry.Key) >= 0 {
+				report("data block #%d, entry #%d: key %s does not sort after %s", i, j, formatKey(entry.Key), formatKey(prevKey))
+			}
+			if firstKey == nil {
+				firstKey = entry.Key
+			}
+			prevKey = entry.Key
+			if checkOrder && lowerBound != nil && compareKeys(entry.Key, lowerBound) <= 0 {
+				report("data block #%d, entry #%d: key %s does not sort after previous index key %s", i, j, formatKey(entry.Key), formatKey(lowerBound))
+			}
+			if checkOrder && compareKeys(entry.Key, e.Key) > 0 {
+				report("data block #%d, entry #%d: key %s sorts after index key %s", i, j, formatKey(entry.Key), formatKey(e.Key))
+			}
+			if len(entry.Value) == 0 || encoder.OpKind(entry.Value[0]) > encoder.OpKindSet {
+				report("data block #%d, entry #%d: malformed value %x", i, j, entry.Value)
+				continue
+			}
+			numEntries++
+			if encoder.OpKind(entry.Value[0]) == encoder.OpKindDelete {
+				numTombstones++
+			}
+		}
+	}
+	if len(layout.Data) > 0 && nextOffset != layout.Filter.Offset {
+		report("data blocks end at offset %d, but the filter block starts at %d", nextOffset, layout.Filter.Offset)
+	}
+	if layout.Version > 1 {
+		p := r.Properties()
+		if p.NumEntries != numEntries || p.NumTombstones != numTombstones || p.NumDataBlocks != uint64(len(layout.Data)) {
+			report("properties record %d entries, %d tombstones, and %d data blocks, but found %d, %d, and %d",
+				p.NumEntries, p.NumTombstones, p.NumDataBlocks, numEntries, numTombstones, len(layout.Data))
+		}
+		if !bytes.Equal(p.SmallestKey, firstKey) || !bytes.Equal(p.LargestKey, prevKey) {
+			report("properties record key range [%s, %s], but found [%s, %s]",
+				formatKey(p.SmallestKey), formatKey(p.LargestKey), formatKey(firstKey), formatKey(prevKey))
+		}
+	}
+
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", path, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	fmt.Printf("%s: OK (%d data blocks, %d entries)\n", path, len(layout.Data), numEntries)
+	return nil
+}
+
+func validKey(key []byte) bool {
+	return len(key) >= encoder.SeqNumSize
+}
+
+func userKey(key []byte) []byte {
+	if !validKey(key) {
+		return key
+	}
+	return encoder.UserKey(key)
+}
+
+func inRange(key []byte) bool {
+	if *start != "" && comparer.Default.Compare(userKey(key), []byte(*start)) < 0 {
+		return false
+	}
+	return beforeEnd(key)
+}
+
+func beforeEnd(key []byte) bool {
+	return *end == "" || comparer.Default.Compare(userKey(key), []byte(*end)) < 0
+}
+
+func formatHandle(h sstable.BlockHandle) string {
+	return fmt.Sprintf("offset=%d length=%d", h.Offset, h.Length)
+}
+
+// formatKey prints an internal key as its user key followed by its sequence number.
+func formatKey(key []byte) string {
+	if key == nil {
+		return "<none>"
+	}
+	if !validKey(key) {
+		return formatBytes(key) + "#<malformed>"
+	}
+	return fmt.Sprintf("%s#%d", formatBytes(encoder.UserKey(key)), encoder.SeqNum(key))
+}
+
+// formatValue prints an encoded value as its operation kind followed by the value itself.
+func formatValue(val []byte) string {
+	if len(val) == 0 {
+		return "<malformed>"
+	}
+	switch encoder.OpKind(val[0]) {
+	case encoder.OpKindSet:
+		return "SET " + formatBytes(val[1:])
+	case encoder.OpKindDelete:
+		return "DEL"
+	}
+	return fmt.Sprintf("<unknown kind %d> %s", val[0], formatBytes(val[1:]))
+}
+
+func formatBytes(p []byte) string {
+	if *format == "hex" {
+		return fmt.Sprintf("%x", p)
+	}
+	return fmt.Sprintf("%q", p)
+}
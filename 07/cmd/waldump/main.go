@@ -0,0 +1,153 @@
+// Command waldump prints the contents of WAL files: every block with the chunks and padding it holds, and every record
+// reassembled from those chunks, decoded as a batch of set and delete operations. Padding, truncated tails, and
+// malformed chunks or records are flagged instead of aborting the dump.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/wal"
+)
+
+var (
+	format    = flag.String("format", "escaped", `Output format of keys and values: "escaped" or "hex".`)
+	blockSize = flag.Int("block-size", wal.DefaultBlockSize, "Block size the WAL was written with (the wal_block_size of the database).")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), "\nWAL Dump\n\nUsage:\n  waldump [flags] <file.log>...\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || (*format != "escaped" && *format != "hex") || *blockSize < wal.MinBlockSize || *blockSize > wal.MaxBlockSize {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		problems, err := dump(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+		} else if problems > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %d problems found\n", path, problems)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// recordAssembler joins the chunks of a record back together.
+type recordAssembler struct {
+	inRecord bool
+	offset   int64 // offset of the first chunk of the record in progress
+	buf      []byte
+	count    int // number of records assembled so far
+}
+
+func dump(path string) (problems int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	report := func(msg string, args ...any) {
+		fmt.Printf("  ! "+msg+"\n", args...)
+		problems++
+	}
+	fmt.Printf("%s\n", path)
+	s := wal.NewScanner(f, *blockSize)
+	var a recordAssembler
+	block := -1
+	for {
+		seg, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return problems, err
+		}
+		if seg.Block != block {
+			block = seg.Block
+			fmt.Printf("block #%d at offset %d\n", block, int64(block)*int64(s.BlockSize()))
+		}
+
+		switch seg.Kind {
+		case wal.SegmentPadding:
+			fmt.Printf("  padding: offset=%d length=%d\n", seg.Offset, seg.Length)
+			continue
+		case wal.SegmentDamaged, wal.SegmentTruncated:
+			kind := "damaged"
+			if seg.Kind == wal.SegmentTruncated {
+				kind = "truncated tail"
+			}
+			report("%s: offset=%d length=%d: %s", kind, seg.Offset, seg.Length, seg.Reason)
+			if a.inRecord {
+				report("record at offset %d lost its remaining chunks", a.offset)
+				a.inRecord = false
+			}
+			continue
+		case wal.SegmentChunk:
+		}
+
+		fmt.Printf("  chunk: offset=%d type=%s length=%d\n", seg.Offset, seg.Type, len(seg.Payload))
+		switch seg.Type {
+		case wal.ChunkTypeFull, wal.ChunkTypeFirst:
+			if a.inRecord {
+				report("record at offset %d is missing its last chunk", a.offset)
+			}
+			a.inRecord, a.offset, a.buf = true, seg.Offset, a.buf[:0]
+		case wal.ChunkTypeMiddle, wal.ChunkTypeLast:
+			if !a.inRecord {
+				report("chunk at offset %d does not belong to any record", seg.Offset)
+				continue
+			}
+		}
+		a.buf = append(a.buf, seg.Payload...)
+		if seg.Type == wal.ChunkTypeFull || seg.Type == wal.ChunkTypeLast {
+			a.inRecord = false
+			if !printRecord(a.count, a.offset, a.buf) {
+				report("record at offset %d does not hold a valid batch", a.offset)
+			}
+			a.count++
+		}
+	}
+	if a.inRecord {
+		report("truncated tail: record at offset %d is missing its last chunk", a.offset)
+	}
+	return problems, nil
+}
+
+func printRecord(num int, offset int64, record []byte) bool {
+	fmt.Printf("  record #%d: offset=%d length=%d\n", num, offset, len(record))
+	entries, err := db.DecodeBatch(record)
+	if err != nil {
+		fmt.Printf("    %s\n", formatBytes(record))
+		return false
+	}
+	for _, e := range entries {
+		if e.Kind == encoder.OpKindDelete {
+			fmt.Printf("    #%d DEL %s\n", e.SeqNum, formatBytes(e.Key))
+		} else {
+			fmt.Printf("    #%d SET %s %s\n", e.SeqNum, formatBytes(e.Key), formatBytes(e.Value))
+		}
+	}
+	return true
+}
+
+func formatBytes(p []byte) string {
+	if *format == "hex" {
+		return fmt.Sprintf("%x", p)
+	}
+	return fmt.Sprintf("%q", p)
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/memtable"
+)
+
+// batch header: sequence number of the first entry (8 bytes) followed by the number of entries (4 bytes)
+const batchHeaderSize = 12
+
+var ErrBatchCorrupted = errors.New("batch corrupted")
+
+// Batch collects Set and Delete operations that DB.Apply applies atomically. The batch is written to the WAL as a
+// single record, so after a crash either all of its operations are recovered or none of them are. The zero value is an
+// empty batch ready to use.
+type Batch struct {
+	data    []byte // header followed by entries: op kind, key length (uvarint), key, value length (uvarint), value
+	count   int
+	memSize int // space the entries take up once inserted into a memtable
+}
+
+// Set adds the insertion of "key" with value "val" to the batch.
+func (b *Batch) Set(key, val []byte) {
+	b.appendEntry(encoder.OpKindSet, key, val)
+	b.memSize += memtable.EntrySize(key, val)
+}
+
+// Delete adds the deletion of "key" to the batch.
+func (b *Batch) Delete(key []byte) {
+	b.appendEntry(encoder.OpKindDelete, key, nil)
+	b.memSize += memtable.EntrySize(key, nil)
+}
+
+func (b *Batch) appendEntry(kind encoder.OpKind, key, val []byte) {
+	if len(b.data) == 0 {
+		b.data = append(b.data, make([]byte, batchHeaderSize)...)
+	}
+	b.data = append(b.data, byte(kind))
+	b.data = binary.AppendUvarint(b.data, uint64(len(key)))
+	b.data = append(b.data, key...)
+	if kind == encoder.OpKindSet {
+		b.data = binary.AppendUvarint(b.data, uint64(len(val)))
+		b.data = append(b.data, val...)
+	}
+	b.count++
+}
+
+// append adds all operations of "other" to the batch.
+func (b *Batch) append(other *Batch) {
+	if len(b.data) == 0 {
+		b.data = append(b.data, make([]byte, batchHeaderSize)...)
+	}
+	b.data = append(b.data, other.data[batchHeaderSize:]...)
+	b.count += other.count
+	b.memSize += other.memSize
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return b.count
+}
+
+// Reset empties the batch, retaining its buffer for reuse.
+func (b *Batch) Reset() {
+	b.data = b.data[:0]
+	b.count = 0
+	b.memSize = 0
+}
+
+// seal stamps the header with "seqNum" and the number of entries, and returns the encoded batch.
+func (b *Batch) seal(seqNum uint64) []byte {
+	binary.LittleEndian.PutUint64(b.data, seqNum)
+	binary.LittleEndian.PutUint32(b.data[8:], uint32(b.count))
+	return b.data
+}
+
+// BatchEntry is a single decoded operation of a batch.
+type BatchEntry struct {
+	Kind   encoder.OpKind
+	Key    []byte
+	Value  []byte // nil for deletions
+	SeqNum uint64
+}
+
+// DecodeBatch parses an encoded batch in full. An error is returned unless every entry announced by the header is
+// present and well-formed, so a damaged batch is never applied partially.
+func DecodeBatch(data []byte) ([]BatchEntry, error) {
+	if len(data) < batchHeaderSize {
+		return nil, ErrBatchCorrupted
+	}
+	seqNum := binary.LittleEndian.Uint64(data)
+	count := int(binary.LittleEndian.Uint32(data[8:]))
+	buf := data[batchHeaderSize:]
+
+	entries := make([]BatchEntry, 0, count)
+	for len(buf) > 0 {
+		kind := encoder.OpKind(buf[0])
+		if kind != encoder.OpKindSet && kind != encoder.OpKindDelete {
+			return nil, ErrBatchCorrupted
+		}
+		e := BatchEntry{Kind: kind, SeqNum: seqNum + uint64(len(entries))}
+		var ok bool
+		if e.Key, buf, ok = readLengthPrefixed(buf[1:]); !ok {
+			return nil, ErrBatchCorrupted
+		}
+		if kind == encoder.OpKindSet {
+			if e.Value, buf, ok = readLengthPrefixed(buf); !ok {
+				return nil, ErrBatchCorrupted
+			}
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != count {
+		return nil, ErrBatchCorrupted
+	}
+	return entries, nil
+}
+
+func readLengthPrefixed(buf []byte) (p, rest []byte, ok bool) {
+	n, m := binary.Uvarint(buf)
+	if m <= 0 || uint64(len(buf)-m) < n {
+		return nil, nil, false
+	}
+	return buf[m : m+int(n)], buf[m+int(n):], true
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+const (
+	batchTestBatches = 8
+	batchTestKeys    = 10
+)
+
+func batchKey(batch, k int) []byte {
+	return []byte(fmt.Sprintf("b%d-k%d", batch, k))
+}
+
+// writeBatches applies batches that each set "batchTestKeys" keys and delete the first key of the batch before it.
+// The values are large enough for every batch to span several blocks of the single WAL file, whose name is returned.
+func writeBatches(t *testing.T, fs vfs.FS) string {
+	d, err := Open("db", &Options{FS: fs, MemtableSizeLimit: 1 << 20, WALBlockSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := []byte(strings.Repeat("v", 100))
+	for i := 0; i < batchTestBatches; i++ {
+		var b Batch
+		for k := 0; k < batchTestKeys; k++ {
+			b.Set(batchKey(i, k), val)
+		}
+		if i > 0 {
+			b.Delete(batchKey(i-1, 0))
+		}
+		if err = d.Apply(&b, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, err := fs.List("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".log") {
+			logs = append(logs, filepath.Join("db", name))
+		}
+	}
+	if len(logs) != 1 {
+		t.Fatalf("got WAL files %v, want one", logs)
+	}
+	return logs[0]
+}
+
+// checkBatches makes sure that the first "n" batches were recovered in full and none of the others.
+func checkBatches(t *testing.T, d *DB, n int) {
+	t.Helper()
+	for i := 0; i < batchTestBatches; i++ {
+		for k := 0; k < batchTestKeys; k++ {
+			_, err := d.Get(batchKey(i, k))
+			// the first key of a batch is deleted by the next one
+			want := i < n && (k > 0 || i == n-1)
+			if got := err == nil; got != want {
+				t.Fatalf("batch %d key %d: got present = %v, want %v (%d batches recovered)", i, k, got, want, n)
+			}
+		}
+	}
+}
+
+func TestBatchReplay(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name string
+		// tear shortens the WAL contents "buf", whose records end at "end"
+		tear func(buf []byte, end int) []byte
+		want int
+	}{
+		{"intact", func(buf []byte, end int) []byte { return buf }, batchTestBatches},
+		{"torn near the start", func(buf []byte, end int) []byte { return buf[:end-1050] }, batchTestBatches - 1},
+		{"torn in the middle", func(buf []byte, end int) []byte { return buf[:end-600] }, batchTestBatches - 1},
+		{"torn at the end", func(buf []byte, end int) []byte { return buf[:end-1] }, batchTestBatches - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := vfs.NewMem()
+			log := writeBatches(t, fs)
+			buf, err := vfs.ReadFile(fs, log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rewriteFile(t, fs, log, tt.tear(buf, recordsEnd(buf)))
+
+			d, err := Open("db", &Options{FS: fs, WALBlockSize: 512})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			checkBatches(t, d, tt.want)
+		})
+	}
+}
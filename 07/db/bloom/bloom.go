@@ -0,0 +1,86 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Filter is a Bloom filter: a bit array followed by a single byte holding the number of probes per key. It answers
+// whether a key may be part of the set it was built from, with false positives but never false negatives.
+type Filter []byte
+
+// NewFilter builds a filter from the hashes (see Hash) of a set of keys, spending "bitsPerKey" bits on every key.
+// Ten bits per key yield a false positive rate of roughly 1%.
+func NewFilter(hashes []uint32, bitsPerKey int) Filter {
+	// the optimal number of probes is bitsPerKey * ln(2)
+	k := uint8(max(1, min(30, int(float64(bitsPerKey)*math.Ln2))))
+
+	// small filters would have a very high false positive rate, so enforce a minimum size
+	nBits := max(64, len(hashes)*bitsPerKey)
+	nBytes := (nBits + 7) / 8
+	nBits = nBytes * 8
+
+	f := make(Filter, nBytes+1)
+	for _, h := range hashes {
+		// use double hashing to derive all probes from a single hash value
+		delta := h>>17 | h<<15
+		for j := uint8(0); j < k; j++ {
+			bitPos := h % uint32(nBits)
+			f[bitPos/8] |= 1 << (bitPos % 8)
+			h += delta
+		}
+	}
+	f[nBytes] = k
+	return f
+}
+
+// MayContain reports whether "key" may be part of the filter's set. A false result means that it certainly is not.
+func (f Filter) MayContain(key []byte) bool {
+	if len(f) < 2 {
+		return false
+	}
+	nBytes := len(f) - 1
+	nBits := uint32(nBytes * 8)
+	k := f[nBytes]
+	if k > 30 {
+		return true // reserved for filter encodings not known to this version
+	}
+
+	h := Hash(key)
+	delta := h>>17 | h<<15
+	for j := uint8(0); j < k; j++ {
+		bitPos := h % nBits
+		if f[bitPos/8]&(1<<(bitPos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// Hash computes the 32-bit hash of "key" used for building and probing filters (similar to Murmur hashing).
+func Hash(key []byte) uint32 {
+	const (
+		seed = 0xbc9f1d34
+		m    = 0xc6a4a793
+	)
+	h := uint32(seed) ^ uint32(len(key))*m
+	for ; len(key) >= 4; key = key[4:] {
+		h += binary.LittleEndian.Uint32(key)
+		h *= m
+		h ^= h >> 16
+	}
+	switch len(key) {
+	case 3:
+		h += uint32(key[2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(key[1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(key[0])
+		h *= m
+		h ^= h >> 24
+	}
+	return h
+}
@@ -0,0 +1,68 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func buildFilter(numKeys, bitsPerKey int) Filter {
+	hashes := make([]uint32, numKeys)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key%06d", i)))
+	}
+	return NewFilter(hashes, bitsPerKey)
+}
+
+func TestNoFalseNegatives(t *testing.T) {
+	for _, numKeys := range []int{1, 10, 100, 1000, 10000} {
+		for _, bitsPerKey := range []int{1, 5, 10, 20} {
+			f := buildFilter(numKeys, bitsPerKey)
+			for i := 0; i < numKeys; i++ {
+				if key := fmt.Sprintf("key%06d", i); !f.MayContain([]byte(key)) {
+					t.Fatalf("%d keys, %d bits per key: %s reported absent", numKeys, bitsPerKey, key)
+				}
+			}
+		}
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	tests := []struct {
+		bitsPerKey int
+		maxRate    float64
+	}{
+		{5, 0.15},
+		{10, 0.02},
+		{20, 0.001},
+	}
+	const numKeys, numProbes = 10000, 100000
+	for _, tt := range tests {
+		f := buildFilter(numKeys, tt.bitsPerKey)
+		falsePositives := 0
+		for i := 0; i < numProbes; i++ {
+			if f.MayContain([]byte(fmt.Sprintf("absent%06d", i))) {
+				falsePositives++
+			}
+		}
+		if rate := float64(falsePositives) / numProbes; rate > tt.maxRate {
+			t.Errorf("%d bits per key: false positive rate %.4f, want at most %.4f", tt.bitsPerKey, rate, tt.maxRate)
+		}
+	}
+}
+
+func TestMalformedFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", nil, false},
+		{"probes only", Filter{6}, false},
+		{"unknown encoding", Filter{0, 0, 0, 0, 0, 0, 0, 0, 31}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.MayContain([]byte("key")); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
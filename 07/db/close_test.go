@@ -0,0 +1,142 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+func TestOperationsFailAfterClose(t *testing.T) {
+	discardLogs(t)
+	d, err := Open("db", &Options{FS: vfs.NewMem()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Set([]byte("key"), []byte("val")); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{"Set", func() error { return d.Set([]byte("key"), []byte("val")) }},
+		{"Delete", func() error { return d.Delete([]byte("key")) }},
+		{"Apply", func() error {
+			var b Batch
+			b.Set([]byte("key"), []byte("val"))
+			return d.Apply(&b, nil)
+		}},
+		{"Get", func() error {
+			_, err := d.Get([]byte("key"))
+			return err
+		}},
+		{"NewIterator", func() error {
+			_, err := d.NewIterator(nil, nil)
+			return err
+		}},
+		{"NewSnapshot", func() error {
+			_, err := d.NewSnapshot()
+			return err
+		}},
+		{"Close", d.Close},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, ErrClosed) {
+				t.Fatalf("got %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+// TestConcurrentClose checks that a Close racing with another one only returns once the database has been shut down,
+// i.e., once the data directory can be opened again.
+func TestConcurrentClose(t *testing.T) {
+	discardLogs(t)
+	opts := &Options{FS: vfs.NewMem()}
+	d, err := Open("db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pretend that background work is running, which holds up the first Close
+	d.mu.Lock()
+	d.bgScheduled = true
+	d.mu.Unlock()
+	first := make(chan error, 1)
+	go func() { first <- d.Close() }()
+	for closing := false; !closing; {
+		d.mu.Lock()
+		closing = d.closed
+		d.mu.Unlock()
+	}
+
+	second := make(chan error, 1)
+	go func() { second <- d.Close() }()
+	select {
+	case err = <-second:
+		t.Fatalf("second Close returned %v before the first one finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	d.mu.Lock()
+	d.bgScheduled = false
+	d.bgCond.Broadcast()
+	d.mu.Unlock()
+
+	if err = <-second; !errors.Is(err, ErrClosed) {
+		t.Fatalf("second Close: got %v, want ErrClosed", err)
+	}
+	// the data directory must have been released by the time the second Close returns
+	d2, err := Open("db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = errors.Join(d2.Close(), <-first); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReadRacingClose checks that a lookup that captured its view of the database before Close does not open tables
+// in the closed table cache afterward.
+func TestReadRacingClose(t *testing.T) {
+	discardLogs(t)
+	d, err := Open("db", &Options{FS: vfs.NewMem(), MemtableSizeLimit: 1 << 10, MemtableFlushThreshold: 2 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 200; k++ {
+		if err = d.Set([]byte(fmt.Sprintf("key%03d", k)), []byte("val")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForBackgroundWork(d)
+	rs, err := d.loadReadState(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := rs.version.tables()
+	if len(tables) == 0 {
+		t.Fatal("no tables were flushed")
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, meta := range tables {
+		if _, err = d.tableCache.get(meta, meta.Smallest(), rs.seqNum); !errors.Is(err, ErrClosed) {
+			t.Fatalf("table %d: got %v, want ErrClosed", meta.FileNum(), err)
+		}
+	}
+	d.unrefVersion(rs.version)
+	d.tableCache.mu.Lock()
+	defer d.tableCache.mu.Unlock()
+	if n := d.tableCache.lru.Len(); n != 0 {
+		t.Fatalf("%d tables opened after Close", n)
+	}
+}
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"errors"
+	"log"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/storage"
+)
+
+// compaction merges the tables in inputs[0] (at "level") with the overlapping tables in inputs[1] (at "level"+1).
+type compaction struct {
+	version *version // version the inputs were picked from
+	level   int
+	inputs  [2][]*storage.FileMetadata
+}
+
+func (c *compaction) outputLevel() int {
+	return c.level + 1
+}
+
+// maxLevelSize returns the size (in bytes) that "level" is allowed to reach before being compacted.
+func (o *Options) maxLevelSize(level int) int64 {
+	size := int64(o.BaseLevelSize)
+	for l := 1; l < level; l++ {
+		size *= int64(o.LevelSizeMultiplier)
+	}
+	return size
+}
+
+// pickCompaction selects the level whose score (its size relative to its target) is the highest. Level 0 is scored
+// by the number of tables rather than by size, since every lookup must consult all of its tables.
+func (d *DB) pickCompaction() *compaction {
+	v := d.current
+	bestLevel, bestScore := -1, 1.0
+	for level := 0; level < numLevels-1; level++ {
+		var score float64
+		if level == 0 {
+			score = float64(len(v.levels[0])) / float64(d.opts.L0CompactionTrigger)
+		} else {
+			score = float64(v.levelSize(level)) / float64(d.opts.maxLevelSize(level))
+		}
+		if score >= bestScore {
+			bestLevel, bestScore = level, score
+		}
+	}
+	if bestLevel < 0 {
+		return nil
+	}
+
+	c := &compaction{version: v, level: bestLevel}
+	if bestLevel == 0 {
+		// Level 0 tables may overlap each other, so they are compacted all at once.
+		c.inputs[0] = append(c.inputs[0], v.levels[0]...)
+	} else {
+		c.inputs[0] = append(c.inputs[0], d.nextCompactionInput(bestLevel))
+	}
+	smallest, largest := keyRange(d.cmp, c.inputs[0])
+	c.inputs[1] = v.overlaps(c.outputLevel(), smallest, largest)
+	return c
+}
+
+// nextCompactionInput picks the table following the one compacted last at "level", cycling through the key space.
+func (d *DB) nextCompactionInput(level int) *storage.FileMetadata {
+	files := d.current.levels[level]
+	pointer := d.compactPointers[level]
+	for _, f := range files {
+		if pointer == nil || d.cmp.Compare(f.Smallest(), pointer) > 0 {
+			return f
+		}
+	}
+	return files[0]
+}
+
+// keyRange returns the smallest and largest key covered by "files".
+func keyRange(cmp comparer.Comparer, files []*storage.FileMetadata) (smallest, largest []byte) {
+	for _, f := range files {
+		if smallest == nil || cmp.Compare(f.Smallest(), smallest) < 0 {
+			smallest = f.Smallest()
+		}
+		if largest == nil || cmp.Compare(f.Largest(), largest) > 0 {
+			largest = f.Largest()
+		}
+	}
+	return smallest, largest
+}
+
+// compact merges the input tables into new tables at the output level, installs a version reflecting the change,
+// and removes the input files from disk once no reader uses them anymore. It is called with d.mu held, but releases
+// it while merging the tables.
+func (d *DB) compact(c *compaction) error {
+	smallestSnapshot := d.smallestSnapshot()
+	d.mu.Unlock()
+	outputs, err := d.runCompaction(c, smallestSnapshot)
+	d.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	edit := &storage.VersionEdit{}
+	for i, files := range c.inputs {
+		for _, f := range files {
+			edit.Deleted = append(edit.Deleted, storage.DeletedFileEntry{Level: c.level + i, FileNum: f.FileNum()})
+		}
+	}
+	for _, f := range outputs {
+		edit.Added = append(edit.Added, storage.NewFileEntry{Level: c.outputLevel(), Meta: f})
+	}
+	if err = d.logAndApply(edit); err != nil {
+		return err
+	}
+	_, largest := keyRange(d.cmp, c.inputs[0])
+	d.compactPointers[c.level] = largest
+
+	log.Printf("Compacted %d tables at level %d and %d tables at level %d into %d tables.",
+		len(c.inputs[0]), c.level, len(c.inputs[1]), c.outputLevel(), len(outputs))
+
+	for _, files := range c.inputs {
+		d.zombies = append(d.zombies, files...)
+	}
+	d.deleteObsoleteTables()
+	return nil
+}
+
+// runCompaction merges the input tables of "c" and returns the tables it produced.
+func (d *DB) runCompaction(c *compaction, smallestSnapshot uint64) ([]*storage.FileMetadata, error) {
+	var iters []internalIterator
+	// Level 0 tables are ordered from oldest to newest, but the merge expects the most recent data first.
+	for j := len(c.inputs[0]) - 1; j >= 0; j-- {
+		iter, err := d.newTableIterator(c.inputs[0][j])
+		if err != nil {
+			closeAll(iters)
+			return nil, err
+		}
+		iters = append(iters, iter)
+	}
+	for _, f := range c.inputs[1] {
+		iter, err := d.newTableIterator(f)
+		if err != nil {
+			closeAll(iters)
+			return nil, err
+		}
+		iters = append(iters, iter)
+	}
+	iter := newMergingIter(d.compareKeys, iters)
+
+	outputs, err := d.writeCompactionOutputs(c, iter, smallestSnapshot)
+	err = errors.Join(err, iter.Close())
+	if err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// writeCompactionOutputs drains "iter" into one or more tables, splitting them once they reach Options.TargetFileSize.
+// The versions of a key are never split across two tables, so the tables of a level never overlap.
+func (d *DB) writeCompactionOutputs(c *compaction, iter *mergingIter, smallestSnapshot uint64) ([]*storage.FileMetadata, error) {
+	var outputs []*storage.FileMetadata
+	var w *tableBuilder
+	var err error
+
+	elideTombstone := func(key []byte) bool {
+		return c.isBaseLevelForKey(key)
+	}
+	i := newCompactionIter(iter, d.cmp, smallestSnapshot, elideTombstone)
+	for ok := i.First(); ok; ok = i.Next() {
+		if w != nil && w.size() >= d.opts.TargetFileSize && d.cmp.Compare(encoder.UserKey(i.Key()), w.largest) != 0 {
+			if err = w.finish(); err != nil {
+				return nil, errors.Join(err, w.abort())
+			}
+			outputs = append(outputs, w.meta)
+			w = nil
+		}
+		if w == nil {
+			if w, err = d.newTableBuilder(); err != nil {
+				return nil, err
+			}
+		}
+		if err = w.add(i.Key(), i.Value()); err != nil {
+			return nil, errors.Join(err, w.abort())
+		}
+	}
+	if err = iter.Error(); err != nil {
+		if w != nil {
+			err = errors.Join(err, w.abort())
+		}
+		return nil, err
+	}
+	if w != nil {
+		if err = w.finish(); err != nil {
+			return nil, errors.Join(err, w.abort())
+		}
+		outputs = append(outputs, w.meta)
+	}
+	return outputs, nil
+}
+
+// isBaseLevelForKey reports whether none of the levels below the output level contain a table whose range includes
+// "key".
+func (c *compaction) isBaseLevelForKey(key []byte) bool {
+	for l := c.outputLevel() + 1; l < numLevels; l++ {
+		if len(c.version.overlaps(l, key, key)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// compactionIter filters the entries of an internal iterator, dropping the versions that no reader can observe anymore.
+// A version is dropped when a more recent version of the same key is visible to every open snapshot. A tombstone is
+// also dropped when it is visible to every open snapshot and "elideTombstone" confirms that no older version of the
+// deleted key is left in the levels below.
+type compactionIter struct {
+	iter             internalIterator
+	cmp              comparer.Comparer
+	smallestSnapshot uint64
+	elideTombstone   func(key []byte) bool
+
+	userKey       []byte
+	hasUserKey    bool
+	lastSeqForKey uint64 // sequence number of the previous version of the current key
+
+	encoder *encoder.Encoder
+}
+
+func newCompactionIter(iter internalIterator, cmp comparer.Comparer, smallestSnapshot uint64, elideTombstone func(key []byte) bool) *compactionIter {
+	return &compactionIter{
+		iter:             iter,
+		cmp:              cmp,
+		smallestSnapshot: smallestSnapshot,
+		elideTombstone:   elideTombstone,
+		encoder:          encoder.NewEncoder(),
+	}
+}
+
+func (c *compactionIter) First() bool {
+	c.hasUserKey = false
+	return c.skipDropped(c.iter.First())
+}
+
+func (c *compactionIter) Next() bool {
+	return c.skipDropped(c.iter.Next())
+}
+
+func (c *compactionIter) skipDropped(ok bool) bool {
+	for ; ok; ok = c.iter.Next() {
+		key := c.iter.Key()
+		userKey, seqNum := encoder.UserKey(key), encoder.SeqNum(key)
+		if !c.hasUserKey || c.cmp.Compare(userKey, c.userKey) != 0 {
+			c.userKey = append(c.userKey[:0], userKey...)
+			c.hasUserKey = true
+			c.lastSeqForKey = encoder.MaxSeqNum
+		}
+		lastSeqForKey := c.lastSeqForKey
+		c.lastSeqForKey = seqNum
+
+		if lastSeqForKey <= c.smallestSnapshot {
+			continue // shadowed by a more recent version visible to every snapshot
+		}
+		if seqNum <= c.smallestSnapshot && c.elideTombstone != nil &&
+			c.encoder.Parse(c.iter.Value()).IsTombstone() && c.elideTombstone(userKey) {
+			continue // nothing left to delete
+		}
+		return true
+	}
+	return false
+}
+
+func (c *compactionIter) Key() []byte {
+	return c.iter.Key()
+}
+
+func (c *compactionIter) Value() []byte {
+	return c.iter.Value()
+}
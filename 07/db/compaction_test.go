@@ -0,0 +1,128 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+// discardLogs silences the database, which logs every lookup and every background error, for the duration of a test.
+func discardLogs(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+}
+
+// waitForBackgroundWork blocks until no flush or compaction is running or pending.
+func waitForBackgroundWork(d *DB) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.bgScheduled {
+		d.bgCond.Wait()
+	}
+}
+
+func levelFileNums(d *DB) [numLevels][]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var levels [numLevels][]int
+	for l, files := range d.current.levels {
+		for _, f := range files {
+			levels[l] = append(levels[l], f.FileNum())
+		}
+	}
+	return levels
+}
+
+func TestCompactionLevelsSurviveReopen(t *testing.T) {
+	discardLogs(t)
+	opts := &Options{FS: vfs.NewMem(), MemtableSizeLimit: 1 << 10, MemtableFlushThreshold: 2 << 10}
+	d, err := Open("db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// overwrite the same keys over and over, so that the tables compacted into the lower levels hold stale values
+	const numKeys, numRounds = 200, 20
+	for r := 0; r < numRounds; r++ {
+		for k := 0; k < numKeys; k++ {
+			if err = d.Set([]byte(fmt.Sprintf("key%03d", k)), []byte(fmt.Sprintf("val%02d", r))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	waitForBackgroundWork(d)
+	before := levelFileNums(d)
+	if len(before[1]) == 0 {
+		t.Fatalf("no table was compacted into level 1: %v", before)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = Open("db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	// replaying the WAL may add a table to level 0, but the other levels must be restored as they were
+	if after := levelFileNums(d); fmt.Sprint(after[1:]) != fmt.Sprint(before[1:]) {
+		t.Fatalf("levels changed across reopen:\nbefore %v\nafter  %v", before, after)
+	}
+	want := fmt.Sprintf("val%02d", numRounds-1)
+	for k := 0; k < numKeys; k++ {
+		if got, err := d.Get([]byte(fmt.Sprintf("key%03d", k))); err != nil || string(got) != want {
+			t.Fatalf("key%03d: got %q (%v), want %q", k, got, err, want)
+		}
+	}
+}
+
+func TestBackgroundErrorFailsWrites(t *testing.T) {
+	discardLogs(t)
+	// the first table written by the background goroutine cannot be created
+	fs := vfs.NewFaultFS(vfs.NewMem(), vfs.Injection{Op: vfs.OpCreate, Suffix: ".sst.tmp", N: 1, Fault: vfs.FaultError})
+	d, err := Open("db", &Options{FS: fs, MemtableSizeLimit: 1 << 10, MemtableFlushThreshold: 2 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10000 && err == nil; i++ {
+		err = d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("val"))
+	}
+	if !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("got %v, want writes to fail with the error of the background work", err)
+	}
+	if err = d.Close(); !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("got %v, want Close to report the error of the background work", err)
+	}
+}
+
+func TestFailedTableWriteRemovesTempFile(t *testing.T) {
+	discardLogs(t)
+	mem := vfs.NewMem()
+	// the first table is written in full, but cannot be synced
+	fs := vfs.NewFaultFS(mem, vfs.Injection{Op: vfs.OpSync, Suffix: ".sst.tmp", N: 1, Fault: vfs.FaultError})
+	d, err := Open("db", &Options{FS: fs, MemtableSizeLimit: 1 << 10, MemtableFlushThreshold: 2 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10000 && err == nil; i++ {
+		err = d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("val"))
+	}
+	if !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("got %v, want writes to fail with the error of the background work", err)
+	}
+	d.Close()
+	names, err := mem.List("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, ".tmp") {
+			t.Errorf("temporary file %s left behind", name)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package comparer
+
+import "bytes"
+
+// Comparer defines the order of user keys. The order must never change for a given name, as the name is recorded when
+// a database is created and every *.sst file is sorted accordingly.
+type Comparer interface {
+	// Compare returns -1, 0, or +1 depending on whether "a" sorts before, equal to, or after "b".
+	Compare(a, b []byte) int
+	// Name identifies the order. Opening a database with a comparer of a different name fails.
+	Name() string
+	// Separator appends to "dst" a key k, preferably shorter than "a", such that a <= k < b. Appending "a" itself is
+	// always correct. It is used to shorten the keys of index blocks.
+	Separator(dst, a, b []byte) []byte
+	// Successor appends to "dst" a key k, preferably shorter than "a", such that a <= k. Appending "a" itself is always
+	// correct.
+	Successor(dst, a []byte) []byte
+}
+
+// Default orders keys lexicographically byte by byte, like bytes.Compare.
+var Default Comparer = bytewise{}
+
+type bytewise struct{}
+
+func (bytewise) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewise) Name() string {
+	return "bytewise"
+}
+
+func (bytewise) Separator(dst, a, b []byte) []byte {
+	// find the length of the common prefix
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	// increment the first differing byte of "a" if the result still sorts before "b"
+	if n < len(a) && n < len(b) && a[n] < 0xff && a[n]+1 < b[n] {
+		dst = append(dst, a[:n+1]...)
+		dst[len(dst)-1]++
+		return dst
+	}
+	return append(dst, a...)
+}
+
+func (bytewise) Successor(dst, a []byte) []byte {
+	// increment the first byte that can be incremented and drop the rest
+	for i, c := range a {
+		if c != 0xff {
+			dst = append(dst, a[:i+1]...)
+			dst[len(dst)-1]++
+			return dst
+		}
+	}
+	return append(dst, a...)
+}
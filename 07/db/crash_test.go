@@ -0,0 +1,287 @@
+package db
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+var (
+	crashSeed   = flag.Int64("crash.seed", 0, "seed of TestCrashRecovery, random by default")
+	crashRounds = flag.Int("crash.rounds", 50, "number of crashes simulated by TestCrashRecovery")
+)
+
+const (
+	crashDir          = "db"
+	crashOpsPerRound  = 300
+	crashKeySpace     = 500
+	crashMaxValueSize = 200
+)
+
+// crashHarness runs a random workload against a database held in memory, crashes it, and checks that the recovered
+// database holds exactly the acknowledged writes. Only the first write that failed because of an injected fault may or
+// may not have survived, but then with all of its changes. Writes failing after it must not have survived.
+type crashHarness struct {
+	t     *testing.T
+	seed  int64
+	round int
+	rng   *rand.Rand
+	fs    *vfs.MemFS
+	acked map[string]string // contents produced by the acknowledged writes
+	// pending holds the changes of the first write that failed in the round, with nil marking a deletion
+	pending map[string]*string
+}
+
+// TestCrashRecovery simulates crashes at random points of writes, WAL rotations, flushes and compactions, as well as
+// while the database is being opened. Every round derives its workload and its faults from the seed, so a failure can
+// be replayed with -crash.seed, although the exact crash point may vary with the timing of the background work.
+func TestCrashRecovery(t *testing.T) {
+	seed := *crashSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rounds := *crashRounds
+	if testing.Short() {
+		rounds = min(rounds, 10)
+	}
+	t.Logf("seed %d", seed)
+
+	discardLogs(t)
+
+	h := &crashHarness{t: t, seed: seed, fs: vfs.NewMem(), acked: make(map[string]string)}
+	for h.round = 0; h.round < rounds; h.round++ {
+		h.rng = rand.New(rand.NewSource(seed + int64(h.round)))
+		h.crash()
+		h.verify()
+	}
+}
+
+func (h *crashHarness) fatalf(format string, args ...any) {
+	h.t.Helper()
+	h.t.Fatalf("round %d: %s\nreproduce with: go test -run TestCrashRecovery -crash.seed=%d",
+		h.round, fmt.Sprintf(format, args...), h.seed)
+}
+
+func (h *crashHarness) options(fs vfs.FS) *Options {
+	// small memtables and WAL blocks make flushes, compactions, and records spanning several blocks frequent
+	return &Options{FS: fs, MemtableSizeLimit: 2 << 10, MemtableFlushThreshold: 4 << 10, WALBlockSize: 512}
+}
+
+// crash opens the database on top of a FaultFS and applies a random number of random writes to it. Writes keep coming
+// after an injected error, which must fail them without losing or reordering acknowledged ones, and only stop once the
+// FaultFS has crashed. The FaultFS then crashes, if it hasn't already, dropping everything that was not synced.
+func (h *crashHarness) crash() {
+	ffs := vfs.NewFaultFS(h.fs, h.injections()...)
+	numOps := h.rng.Intn(crashOpsPerRound) + 1
+	d, err := Open(crashDir, h.options(ffs))
+	if err != nil && !errors.Is(err, vfs.ErrInjected) {
+		h.fatalf("open: %v", err)
+	}
+	if err == nil {
+		for i := 0; i < numOps && !ffs.Crashed(); i++ {
+			h.apply(d)
+		}
+	}
+	if err := ffs.Crash(); err != nil {
+		h.fatalf("crash: %v", err)
+	}
+	if d != nil {
+		// releases the lock held by the crashed database, while every write to the file system fails
+		d.Close()
+	}
+}
+
+// injections schedules up to two faults, most of them crashes, at random operations of the round.
+func (h *crashHarness) injections() []vfs.Injection {
+	ops := []vfs.Op{vfs.OpWrite, vfs.OpSync, vfs.OpSyncDir, vfs.OpCreate, vfs.OpRename, vfs.OpRemove}
+	suffixes := []string{"", ".log", ".tmp", "CURRENT"}
+	var schedule []vfs.Injection
+	for n := h.rng.Intn(3); n > 0; n-- {
+		inj := vfs.Injection{Op: ops[h.rng.Intn(len(ops))], Suffix: suffixes[h.rng.Intn(len(suffixes))], Fault: vfs.FaultCrash}
+		switch inj.Op {
+		case vfs.OpWrite:
+			inj.N = h.rng.Intn(2000) + 1
+		case vfs.OpSync:
+			inj.N = h.rng.Intn(crashOpsPerRound) + 1
+		default:
+			inj.N = h.rng.Intn(20) + 1
+		}
+		switch r := h.rng.Intn(10); {
+		case r == 0:
+			inj.Fault = vfs.FaultError
+		case r == 1 && inj.Op == vfs.OpWrite:
+			inj.Fault = vfs.FaultShortWrite
+		}
+		schedule = append(schedule, inj)
+	}
+	return schedule
+}
+
+// apply performs a random write and records its effect in the model. The first write to fail becomes pending, while
+// those failing after it are left out of the model.
+func (h *crashHarness) apply(d *DB) {
+	var b Batch
+	changes := make(map[string]*string)
+	numOps := 1
+	if h.rng.Intn(3) == 0 {
+		numOps = h.rng.Intn(5) + 2
+	}
+	for i := 0; i < numOps; i++ {
+		key := fmt.Sprintf("key%04d", h.rng.Intn(crashKeySpace))
+		if h.rng.Intn(4) == 0 {
+			b.Delete([]byte(key))
+			changes[key] = nil
+			continue
+		}
+		// every value is unique, so that stale versions cannot go unnoticed
+		val := fmt.Sprintf("r%d.%d.%s", h.round, h.rng.Int63(), strings.Repeat("v", h.rng.Intn(crashMaxValueSize)))
+		b.Set([]byte(key), []byte(val))
+		changes[key] = &val
+	}
+	if err := d.Apply(&b, nil); err != nil {
+		if !errors.Is(err, vfs.ErrInjected) {
+			h.fatalf("write failed for a reason other than an injected fault: %v", err)
+		}
+		if h.pending == nil {
+			h.pending = changes
+		}
+		return
+	}
+	if h.pending != nil {
+		h.fatalf("write acknowledged after an earlier one failed")
+	}
+	applyChanges(h.acked, changes)
+}
+
+func applyChanges(m map[string]string, changes map[string]*string) {
+	for key, val := range changes {
+		if val == nil {
+			delete(m, key)
+		} else {
+			m[key] = *val
+		}
+	}
+}
+
+// verify reopens the crashed database and compares its contents with the model.
+func (h *crashHarness) verify() {
+	d, err := Open(crashDir, h.options(h.fs))
+	if err != nil {
+		h.fatalf("reopen after crash: %v", err)
+	}
+	got := h.scan(d)
+	for key, val := range h.acked {
+		if v, err := d.Get([]byte(key)); err != nil || string(v) != val {
+			h.fatalf("get %q: got %q (%v), want %q", key, v, err, val)
+		}
+	}
+	if err = d.Close(); err != nil {
+		h.fatalf("close: %v", err)
+	}
+
+	if h.pending != nil {
+		// the failed write may have survived the crash, in which case all of its changes must have
+		applied := h.matches(got, h.pending)
+		if !applied && !h.matches(got, nil) {
+			h.fatalf("write in flight at the crash partially applied: %s", h.describe(got, h.pending))
+		}
+		if applied {
+			applyChanges(h.acked, h.pending)
+		}
+		h.pending = nil
+	}
+	if diff := diffContents(got, h.acked); diff != "" {
+		h.fatalf("recovered contents differ from acknowledged writes:\n%s", diff)
+	}
+}
+
+// scan returns the contents of the database, making sure that the keys come in ascending order.
+func (h *crashHarness) scan(d *DB) map[string]string {
+	it, err := d.NewIterator(nil, nil)
+	if err != nil {
+		h.fatalf("new iterator: %v", err)
+	}
+	contents := make(map[string]string)
+	var prev string
+	for ok := it.First(); ok; ok = it.Next() {
+		key := string(it.Key())
+		if len(contents) > 0 && key <= prev {
+			h.fatalf("scan: key %q follows %q", key, prev)
+		}
+		contents[key] = string(it.Value())
+		prev = key
+	}
+	if err = errors.Join(it.Error(), it.Close()); err != nil {
+		h.fatalf("scan: %v", err)
+	}
+	return contents
+}
+
+// matches reports whether the keys touched by the pending write hold the values they had before it (for nil
+// "changes") or the values it sets.
+func (h *crashHarness) matches(got map[string]string, changes map[string]*string) bool {
+	for key := range h.pending {
+		want, ok := h.acked[key]
+		if changes != nil {
+			ok = changes[key] != nil
+			if ok {
+				want = *changes[key]
+			}
+		}
+		v, found := got[key]
+		if found != ok || v != want {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *crashHarness) describe(got map[string]string, changes map[string]*string) string {
+	var lines []string
+	for key, val := range changes {
+		before, ok := h.acked[key]
+		if !ok {
+			before = "<none>"
+		}
+		after := "<deleted>"
+		if val != nil {
+			after = *val
+		}
+		now, ok := got[key]
+		if !ok {
+			now = "<none>"
+		}
+		lines = append(lines, fmt.Sprintf("\n  %q: before %q, written %q, recovered %q", key, before, after, now))
+	}
+	slices.Sort(lines)
+	return strings.Join(lines, "")
+}
+
+// diffContents lists the keys whose values differ between "got" and "want", up to a limit.
+func diffContents(got, want map[string]string) string {
+	var diffs []string
+	for key, val := range want {
+		if v, ok := got[key]; !ok {
+			diffs = append(diffs, fmt.Sprintf("  %q: missing, want %q", key, val))
+		} else if v != val {
+			diffs = append(diffs, fmt.Sprintf("  %q: got %q, want %q", key, v, val))
+		}
+	}
+	for key, val := range got {
+		if _, ok := want[key]; !ok {
+			diffs = append(diffs, fmt.Sprintf("  %q: got %q, never acknowledged", key, val))
+		}
+	}
+	slices.Sort(diffs)
+	if len(diffs) > 10 {
+		diffs = append(diffs[:10], fmt.Sprintf("  ... and %d more", len(diffs)-10))
+	}
+	return strings.Join(diffs, "\n")
+}
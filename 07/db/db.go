@@ -2,9 +2,13 @@ package db
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"slices"
+	"sync"
 
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/memtable"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/sstable"
@@ -12,14 +16,34 @@ import (
 	"github.com/cloudcentricdev/golang-tutorials/07/db/wal"
 )
 
-const (
-	memtableSizeLimit      = 4 << 10 // 4 KiB
-	memtableFlushThreshold = 8 << 10 // 8 KiB
-)
+var ErrClosed = errors.New("database closed")
+
+// ErrLocked is returned by Open when another process is using the data directory.
+var ErrLocked = storage.ErrLocked
+
+// ErrMissingManifest is returned by Open for a data directory that holds tables or WAL files, but no MANIFEST
+// describing them, e.g., one written by a version of the database that predates the MANIFEST.
+var ErrMissingManifest = errors.New("data directory holds tables or WAL files but no MANIFEST")
 
+const maxBatchGroupSize = 1 << 20 // 1 MiB, limits how many queued batches are committed together
+
+// DB is safe for concurrent use by multiple goroutines. Writers queue up, and the writer at the front of the queue
+// commits its own batch together with those of the writers behind it, so that they all share a single WAL write and
+// sync. Full memtables are flushed to disk and sstables are compacted by a background goroutine.
 type DB struct {
+	opts        *Options
 	dataStorage *storage.Provider
-	memtables   struct {
+	tableCache  *tableCache
+	blockCache  *sstable.BlockCache
+	cmp         comparer.Comparer
+	compareKeys func(a, b []byte) int // orders internal keys according to cmp
+
+	// mu protects the fields below. It is never held while reading or writing table files. The WAL and the contents of
+	// the mutable memtable are only modified by the writer at the front of the write queue.
+	mu        sync.Mutex
+	bgCond    *sync.Cond // signalled when a round of background work completes, the write queue drains, or Close is done
+	writers   []*writer  // write queue
+	memtables struct {
 		mutable *memtable.Memtable
 		queue   []*memtable.Memtable
 	}
@@ -27,17 +51,59 @@ type DB struct {
 		w  *wal.Writer
 		fm *storage.FileMetadata
 	}
-	sstables []*storage.FileMetadata
-	logs     []*storage.FileMetadata
+	current         *version
+	manifest        *storage.Manifest
+	compactPointers [numLevels][]byte
+	logNum          int
+	seqNum          uint64 // most recently assigned sequence number
+	snapshots       snapshotList
+	logs            []*storage.FileMetadata
+	obsolete        []*storage.FileMetadata
+	pinned          []*version // versions other than the current one that are still referenced by readers
+	zombies         []*storage.FileMetadata
+	bgScheduled     bool
+	bgErr           error // first error encountered by background work or by writing the WAL; once set, all writes fail with it
+	closed          bool  // set by Close, after which every operation fails with ErrClosed
+	shutDown        bool  // set once Close has released every resource of the database
 }
 
-func Open(dirname string) (*DB, error) {
-	dataStorage, err := storage.NewProvider(dirname)
+func Open(dirname string, opts *Options) (_ *DB, err error) {
+	opts = opts.withDefaults()
+	if err = opts.validate(); err != nil {
+		return nil, err
+	}
+	dataStorage, err := storage.NewProvider(opts.FS, dirname)
+	if err != nil {
+		return nil, err
+	}
+	if err = dataStorage.Lock(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			dataStorage.Unlock()
+		}
+	}()
+	db := &DB{opts: opts, dataStorage: dataStorage}
+	db.cmp = db.opts.Comparer
+	db.compareKeys = encoder.KeyComparer(db.cmp.Compare)
+	db.current = &version{cmp: db.cmp}
+	db.blockCache = sstable.NewBlockCache(db.opts.BlockCacheSize)
+	db.tableCache = newTableCache(dataStorage, db.blockCache, db.cmp, db.opts.TableCacheSize)
+	db.bgCond = sync.NewCond(&db.mu)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	found, err := db.loadManifest()
 	if err != nil {
 		return nil, err
 	}
-	db := &DB{dataStorage: dataStorage}
-	if err = db.loadFiles(); err != nil {
+	if err = db.loadFiles(found); err != nil {
+		return nil, err
+	}
+	if err = db.checkOptions(); err != nil {
+		return nil, err
+	}
+	if err = db.createManifest(); err != nil {
 		return nil, err
 	}
 	if err = db.replayWALs(); err != nil {
@@ -46,124 +112,424 @@ func Open(dirname string) (*DB, error) {
 	if err = db.createNewWAL(); err != nil {
 		return nil, err
 	}
-	db.rotateMemtables()
+	if err = db.logAndApply(&storage.VersionEdit{LogNum: db.wal.fm.FileNum(), LastSeqNum: db.seqNum}); err != nil {
+		return nil, err
+	}
+	// files are only deleted once the database has been recovered in full
+	if err = db.removeObsoleteFiles(); err != nil {
+		return nil, err
+	}
+	db.rotateMemtables(0)
+	db.maybeScheduleBackgroundWork()
 	return db, nil
 }
 
-func (d *DB) loadFiles() error {
+// loadManifest reconstructs the most recent version of the LSM tree from the MANIFEST. It reports whether a MANIFEST
+// was found, which is not the case for a new database.
+func (d *DB) loadManifest() (found bool, err error) {
+	edits, err := d.dataStorage.ReadManifest()
+	if err != nil {
+		return false, err
+	}
+	for _, edit := range edits {
+		d.current = d.current.apply(edit)
+		if edit.LogNum != 0 {
+			d.logNum = edit.LogNum
+		}
+		d.seqNum = max(d.seqNum, edit.LastSeqNum)
+	}
+	return edits != nil, nil
+}
+
+// loadFiles sorts the files found in the data directory into WAL files that need replaying and obsolete files left
+// behind by a crash (e.g., half-written tables or compaction inputs that were not deleted yet). Without a MANIFEST
+// ("foundManifest" unset), only a MANIFEST left behind by a crash while creating the database may be present.
+func (d *DB) loadFiles(foundManifest bool) error {
 	meta, err := d.dataStorage.ListFiles()
 	if err != nil {
 		return err
 	}
+	live := make(map[int]bool)
 	for _, f := range meta {
 		switch {
-		case f.IsSSTable():
-			d.sstables = append(d.sstables, f)
-		case f.IsWAL():
+		case !foundManifest && f.IsSSTable():
+			return fmt.Errorf("%w: found table %06d", ErrMissingManifest, f.FileNum())
+		case !foundManifest && f.IsWAL():
+			return fmt.Errorf("%w: found WAL %06d", ErrMissingManifest, f.FileNum())
+		case f.IsSSTable() && d.current.contains(f.FileNum()):
+			live[f.FileNum()] = true
+		case f.IsWAL() && f.FileNum() >= d.logNum:
 			d.logs = append(d.logs, f)
 		default:
-			continue
+			d.obsolete = append(d.obsolete, f)
+		}
+	}
+	for _, f := range d.current.tables() {
+		if !live[f.FileNum()] {
+			return fmt.Errorf("sstable %06d referenced by the MANIFEST is missing", f.FileNum())
 		}
 	}
 	return nil
 }
 
+// createManifest starts a new MANIFEST file, seeded with a snapshot of the version loaded on startup.
+func (d *DB) createManifest() error {
+	snapshot := d.current.snapshot()
+	snapshot.LogNum = d.logNum
+	snapshot.LastSeqNum = d.seqNum
+	m, err := d.dataStorage.CreateManifest(snapshot)
+	if err != nil {
+		return err
+	}
+	d.manifest = m
+	return nil
+}
+
+func (d *DB) removeObsoleteFiles() error {
+	for _, f := range d.obsolete {
+		if err := d.dataStorage.DeleteFile(f); err != nil {
+			return err
+		}
+	}
+	d.obsolete = nil
+	return d.dataStorage.SyncDataDir()
+}
+
+// logAndApply durably records "edit" in the MANIFEST before installing the version it produces.
+func (d *DB) logAndApply(edit *storage.VersionEdit) error {
+	if err := d.manifest.Append(edit); err != nil {
+		return err
+	}
+	d.installVersion(d.current.apply(edit))
+	if edit.LogNum != 0 {
+		d.logNum = edit.LogNum
+	}
+	return nil
+}
+
+// replayWALs restores the contents of the WAL files that were not flushed before the database was last closed, and
+// flushes them into level 0 tables. With WALRecoveryStopAtCorruption, the WAL files following the one holding the
+// first corrupted record are dropped, so that the database recovers to the state it had right before that record.
 func (d *DB) replayWALs() error {
-	for _, fm := range d.logs {
-		if err := d.replayWAL(fm); err != nil {
+	for i, fm := range d.logs {
+		stopped, err := d.replayWAL(fm)
+		if err != nil {
+			return err
+		}
+		if stopped {
+			// the later WAL files must be gone before the corrupted one is deleted by the flush, so that they cannot
+			// be replayed if the database crashes in between
+			if err = d.dropWALs(d.logs[i+1:], fm); err != nil {
+				return err
+			}
+		}
+		// flush all memtables to disk
+		d.rotateMemtables(0)
+		if err = d.flushMemtables(); err != nil {
 			return err
 		}
+		d.memtables.queue, d.memtables.mutable = nil, nil
+		if stopped {
+			break
+		}
 	}
 	d.logs = nil
 	return nil
 }
 
-func (d *DB) replayWAL(fm *storage.FileMetadata) error {
+// replayWAL applies the records of WAL file "fm" to new memtables. It reports whether the replay stopped at a
+// corrupted record.
+func (d *DB) replayWAL(fm *storage.FileMetadata) (stopped bool, err error) {
 	// open WAL file for reading
 	f, err := d.dataStorage.OpenFileForReading(fm)
 	if err != nil {
-		return err
+		return false, err
 	}
 	// create a new reader for iterating the WAL file
-	r := wal.NewReader(f)
+	r := wal.NewReader(f, d.opts.WALBlockSize)
 	// prepare a new memtable to apply records to
 	d.wal.fm = fm
-	m := d.rotateMemtables()
+	m := d.rotateMemtables(0)
 	// start processing records
-	for {
+	for !stopped {
 		// fetch next record from WAL file
-		key, val, err := r.Next()
+		record, err := r.Next()
+		if errors.Is(err, wal.ErrCorrupted) && d.opts.WALRecoveryMode != WALRecoveryFail {
+			log.Printf("Replaying WAL %06d: %v", fm.FileNum(), err)
+			stopped = d.opts.WALRecoveryMode == WALRecoveryStopAtCorruption
+			continue
+		}
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return false, fmt.Errorf("replaying WAL %06d: %w", fm.FileNum(), err)
+		}
+		// decode the batch held by the record in full before applying any of it
+		entries, err := DecodeBatch(record)
+		if err != nil {
+			return false, fmt.Errorf("replaying WAL %06d: %w", fm.FileNum(), err)
 		}
 		// rotate memtable if it's full
-		if !m.HasRoomForWrite(key, val.Value()) {
-			d.rotateMemtables()
+		if memSize := batchMemSize(entries); !m.HasRoomForWrite(memSize) {
+			m = d.rotateMemtables(memSize)
 		}
 		// apply WAL record to memtable
-		if val.IsTombstone() {
-			m.InsertTombstone(key)
-		} else {
-			m.Insert(key, val.Value())
+		if err = applyBatch(m, entries); err != nil {
+			return false, fmt.Errorf("replaying WAL %06d: %w", fm.FileNum(), err)
 		}
+		// restore the most recently assigned sequence number
+		d.seqNum = max(d.seqNum, entries[len(entries)-1].SeqNum)
 	}
-	// flush all memtables to disk
-	d.rotateMemtables()
-	if err = d.flushMemtables(); err != nil {
-		return err
-	}
-	d.memtables.queue, d.memtables.mutable = nil, nil
 	// close WAL file
 	if err = f.Close(); err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	return stopped, nil
 }
 
-func (d *DB) Set(key, val []byte) error {
-	if err := d.wal.w.RecordInsertion(key, val); err != nil {
-		return err
+// dropWALs deletes the WAL files "logs", whose records follow the corrupted record found in WAL file "corrupted".
+func (d *DB) dropWALs(logs []*storage.FileMetadata, corrupted *storage.FileMetadata) error {
+	if len(logs) == 0 {
+		return nil
 	}
-	m, err := d.prepMemtableForKV(key, val)
-	if err != nil {
-		return err
+	for _, fm := range logs {
+		log.Printf("Dropping WAL %06d, as it follows the corrupted WAL %06d", fm.FileNum(), corrupted.FileNum())
+		if err := d.dataStorage.DeleteFile(fm); err != nil {
+			return err
+		}
 	}
-	m.Insert(key, val)
-	d.maybeScheduleFlush()
-	return nil
+	return d.dataStorage.SyncDataDir()
+}
+
+// Close shuts the database down. It stops accepting writes, waits for queued writes and background work to finish,
+// and seals and syncs the WAL. With Options.FlushOnClose, the memtables are then flushed to level 0, so that no WAL
+// needs replaying the next time the database is opened. Finally, every file handle held by the database is released.
+// All iterators must be closed beforehand. Any subsequent call to the DB fails with ErrClosed; a concurrent call to
+// Close does so only once the database has been shut down. Close also returns the error that stopped the background
+// work, if any.
+func (d *DB) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		for !d.shutDown {
+			d.bgCond.Wait()
+		}
+		return ErrClosed
+	}
+	d.closed = true
+	for len(d.writers) > 0 || d.bgScheduled {
+		d.bgCond.Wait()
+	}
+
+	err := d.wal.w.Close()
+	if err == nil && d.opts.FlushOnClose && d.bgErr == nil {
+		// the mutable memtable joins the ones waiting to be flushed, while an empty one takes its place
+		d.rotateMemtables(0)
+		err = d.flushMemtables()
+	}
+	d.memtables.queue, d.memtables.mutable = nil, nil
+	d.tableCache.close()
+	err = errors.Join(d.bgErr, err, d.manifest.Close(), d.dataStorage.Unlock())
+	d.shutDown = true
+	d.bgCond.Broadcast()
+	return err
+}
+
+func (d *DB) Set(key, val []byte) error {
+	var b Batch
+	b.Set(key, val)
+	return d.Apply(&b, nil)
 }
 
 func (d *DB) Delete(key []byte) error {
-	if err := d.wal.w.RecordDeletion(key); err != nil {
-		return err
+	var b Batch
+	b.Delete(key)
+	return d.Apply(&b, nil)
+}
+
+// writer is a call to Apply waiting in the write queue.
+type writer struct {
+	batch *Batch
+	sync  bool // set if the WAL must be synced before Apply returns
+	done  bool // set once the batch was committed by the leader of a group
+	err   error
+	cond  sync.Cond
+}
+
+// Apply atomically applies all operations in "b", assigning them consecutive sequence numbers. The operations become
+// visible to readers together and are recorded in the WAL as part of a single record. Apply returns once the record
+// is as durable as the WAL sync policy or "opts" demand.
+func (d *DB) Apply(b *Batch, opts *WriteOptions) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	w := &writer{batch: b, sync: opts != nil && opts.Sync}
+	w.cond.L = &d.mu
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	d.writers = append(d.writers, w)
+	for !w.done && d.writers[0] != w {
+		w.cond.Wait()
+	}
+	if w.done {
+		return w.err // committed as part of a group led by another writer
 	}
-	m, err := d.prepMemtableForKV(key, nil)
+
+	// w is at the front of the queue, so it leads the group of writers queued behind it
+	group := []*writer{w}
+	m, err := d.makeRoomForWrite(b)
+	if err == nil {
+		var merged *Batch
+		merged, group = d.buildBatchGroup(m)
+		// a single sync covers the whole group, so it is performed if any of its writers asks for it
+		sync := false
+		for _, g := range group {
+			sync = sync || g.sync
+		}
+		err = d.commit(m, merged, sync)
+	}
+	for _, g := range group {
+		d.writers = d.writers[1:]
+		if g != w {
+			g.done, g.err = true, err
+			g.cond.Signal()
+		}
+	}
+	if len(d.writers) > 0 {
+		d.writers[0].cond.Signal()
+	} else {
+		d.bgCond.Broadcast()
+	}
+	return err
+}
+
+// buildBatchGroup merges the batches of the writers at the front of the queue, up to maxBatchGroupSize and as long as
+// they all fit into "m". The batch of the writer at the front is known to fit.
+func (d *DB) buildBatchGroup(m *memtable.Memtable) (*Batch, []*writer) {
+	leader := d.writers[0]
+	group := []*writer{leader}
+	size, memSize := len(leader.batch.data), leader.batch.memSize
+	for _, w := range d.writers[1:] {
+		size += len(w.batch.data)
+		memSize += w.batch.memSize
+		if size > maxBatchGroupSize || !m.HasRoomForWrite(memSize) {
+			break
+		}
+		group = append(group, w)
+	}
+	if len(group) == 1 {
+		return leader.batch, group
+	}
+	merged := &Batch{}
+	for _, w := range group {
+		merged.append(w.batch)
+	}
+	return merged, group
+}
+
+// commit records "b" in the WAL, syncing it if "sync" is set, and applies it to "m". It is called with d.mu held, but
+// releases it while writing.
+func (d *DB) commit(m *memtable.Memtable, b *Batch, sync bool) error {
+	seqNum := d.seqNum + 1
+	d.mu.Unlock()
+	record := b.seal(seqNum)
+	err := d.wal.w.Record(record)
+	if err == nil && sync {
+		err = d.wal.w.Sync()
+	}
+	var entries []BatchEntry
+	if err == nil {
+		entries, err = DecodeBatch(record)
+	}
+	if err == nil {
+		err = applyBatch(m, entries)
+	}
+	d.mu.Lock()
 	if err != nil {
+		// the record may have reached the WAL in part or in full, so neither the WAL nor the sequence numbers of the
+		// batch can be used by another write, which would otherwise be lost or shadowed when the WAL is replayed
+		d.setWriteErr(err)
 		return err
 	}
-	m.InsertTombstone(key)
-	d.maybeScheduleFlush()
+
+	// publish the batch to readers only once all of its entries are in the memtable
+	d.seqNum = entries[len(entries)-1].SeqNum
+	d.maybeScheduleBackgroundWork()
 	return nil
 }
 
-// prepMemtableForKV ensures that the mutable memtable has sufficient space to accommodate the insertion of "key" and "val".
-func (d *DB) prepMemtableForKV(key, val []byte) (*memtable.Memtable, error) {
-	m := d.memtables.mutable
-
-	if !m.HasRoomForWrite(key, val) {
+// makeRoomForWrite ensures that the mutable memtable has sufficient space to accommodate all entries of "b". The WAL
+// is rotated together with the memtable, so that the batch is recorded in the WAL backing the memtable it lands in.
+// Writers are stalled while the memtables waiting to be flushed exceed Options.MemtableStallFactor times the flush
+// threshold.
+func (d *DB) makeRoomForWrite(b *Batch) (*memtable.Memtable, error) {
+	for {
+		if d.closed {
+			return nil, ErrClosed
+		}
+		if d.bgErr != nil {
+			return nil, d.bgErr
+		}
+		m := d.memtables.mutable
+		if m.HasRoomForWrite(b.memSize) {
+			return m, nil
+		}
+		if d.immutableSize() > d.opts.MemtableStallFactor*d.opts.MemtableFlushThreshold {
+			d.bgCond.Wait()
+			continue
+		}
 		if err := d.rotateWAL(); err != nil {
+			// the WAL backing the mutable memtable may already be closed
+			d.setWriteErr(err)
 			return nil, err
 		}
-		m = d.rotateMemtables()
+		m = d.rotateMemtables(b.memSize)
+		d.maybeScheduleBackgroundWork()
+		return m, nil
+	}
+}
+
+// setWriteErr records the failure to write the WAL in bgErr, so that all subsequent writes fail.
+func (d *DB) setWriteErr(err error) {
+	if d.bgErr == nil {
+		log.Printf("Writing the WAL failed: %v", err)
+		d.bgErr = err
 	}
-	return m, nil
 }
 
-func (d *DB) rotateMemtables() *memtable.Memtable {
-	d.memtables.mutable = memtable.NewMemtable(memtableSizeLimit, d.wal.fm)
+// applyBatch inserts "entries" into "m", which must have room for all of them.
+func applyBatch(m *memtable.Memtable, entries []BatchEntry) error {
+	for _, e := range entries {
+		var err error
+		if e.Kind == encoder.OpKindDelete {
+			err = m.InsertTombstone(e.Key, e.SeqNum)
+		} else {
+			err = m.Insert(e.Key, e.Value, e.SeqNum)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func batchMemSize(entries []BatchEntry) int {
+	var size int
+	for _, e := range entries {
+		size += memtable.EntrySize(e.Key, e.Value)
+	}
+	return size
+}
+
+// rotateMemtables replaces the mutable memtable with an empty one that has room for at least "sizeNeeded" bytes, so
+// that writes larger than the size limit can still be applied.
+func (d *DB) rotateMemtables(sizeNeeded int) *memtable.Memtable {
+	d.memtables.mutable = memtable.NewMemtable(max(d.opts.MemtableSizeLimit, sizeNeeded), d.wal.fm, d.cmp)
 	d.memtables.queue = append(d.memtables.queue, d.memtables.mutable)
 	return d.memtables.mutable
 }
@@ -185,62 +551,165 @@ func (d *DB) createNewWAL() error {
 	if err != nil {
 		return err
 	}
-	d.wal.w = wal.NewWriter(logFile)
+	d.wal.w = wal.NewWriter(logFile, d.opts.WALBlockSize, d.opts.WALSyncPolicy)
 	d.wal.fm = fm
 	return nil
 }
 
-func (d *DB) maybeScheduleFlush() {
+// immutableSize returns the total size (in bytes) of the memtables waiting to be flushed.
+func (d *DB) immutableSize() int {
 	var totalSize int
 
-	for i := 0; i < len(d.memtables.queue); i++ {
+	for i := 0; i < len(d.memtables.queue)-1; i++ {
 		totalSize += d.memtables.queue[i].Size()
 	}
+	return totalSize
+}
 
-	if totalSize <= memtableFlushThreshold {
+// needsFlush reports whether the memtables have grown past the flush threshold and at least one of them is full.
+func (d *DB) needsFlush() bool {
+	return len(d.memtables.queue) > 1 && d.immutableSize()+d.memtables.mutable.Size() > d.opts.MemtableFlushThreshold
+}
+
+// maybeScheduleBackgroundWork starts the background goroutine if there are memtables to flush or levels to compact.
+func (d *DB) maybeScheduleBackgroundWork() {
+	if d.bgScheduled || d.bgErr != nil || d.closed {
+		return
+	}
+	if !d.needsFlush() && d.pickCompaction() == nil {
 		return
 	}
+	d.bgScheduled = true
+	go d.backgroundWork()
+}
+
+// backgroundWork performs a single flush or compaction and reschedules itself while more work remains. A failure is
+// recorded in bgErr, which stops all further background work and fails subsequent writes.
+func (d *DB) backgroundWork() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	err := d.flushMemtables()
+	var err error
+	if d.needsFlush() {
+		err = d.flushMemtables()
+	} else if c := d.pickCompaction(); c != nil {
+		err = d.compact(c)
+	}
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Background work failed: %v", err)
+		d.bgErr = err
 	}
+	d.bgScheduled = false
+	d.maybeScheduleBackgroundWork()
+	d.bgCond.Broadcast()
 }
 
+// flushMemtables writes every memtable except the mutable one into level 0 tables. It is called with d.mu held,
+// but releases it while writing the tables.
 func (d *DB) flushMemtables() error {
-	n := len(d.memtables.queue) - 1
-	flushable := d.memtables.queue[:n]
-	d.memtables.queue = d.memtables.queue[n:]
+	flushable := d.memtables.queue[:len(d.memtables.queue)-1]
+	smallestSnapshot := d.smallestSnapshot()
 
+	d.mu.Unlock()
+	var added []storage.NewFileEntry
+	var err error
 	for i := 0; i < len(flushable); i++ {
-		meta := d.dataStorage.PrepareNewSSTFile()
-		f, err := d.dataStorage.OpenFileForWriting(meta)
-		if err != nil {
-			return err
+		if flushable[i].Size() == 0 {
+			continue
 		}
-		w := sstable.NewWriter(f)
-		err = w.Process(flushable[i])
-		if err != nil {
-			return err
+		var meta *storage.FileMetadata
+		if meta, err = d.flushMemtable(flushable[i], smallestSnapshot); err != nil {
+			break
 		}
-		err = w.Close()
+		added = append(added, storage.NewFileEntry{Level: 0, Meta: meta})
+	}
+	d.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	// WAL files older than the one backing the oldest remaining memtable become obsolete once the flush is recorded.
+	remaining := d.memtables.queue[len(flushable):]
+	edit := &storage.VersionEdit{
+		LogNum:     remaining[0].LogFile().FileNum(),
+		LastSeqNum: d.seqNum,
+		Added:      added,
+	}
+	if err = d.logAndApply(edit); err != nil {
+		return err
+	}
+	d.memtables.queue = remaining
+	for i := 0; i < len(flushable); i++ {
+		err := d.dataStorage.DeleteFile(flushable[i].LogFile())
 		if err != nil {
 			return err
 		}
-		d.sstables = append(d.sstables, meta)
-		err = d.dataStorage.DeleteFile(flushable[i].LogFile())
-		if err != nil {
-			return err
+	}
+	return d.dataStorage.SyncDataDir()
+}
+
+// flushMemtable writes the contents of "m" into a new level 0 table. Versions of a key that are shadowed by a more
+// recent version and not referenced by any open snapshot are left out.
+func (d *DB) flushMemtable(m *memtable.Memtable, smallestSnapshot uint64) (*storage.FileMetadata, error) {
+	b, err := d.newTableBuilder()
+	if err != nil {
+		return nil, err
+	}
+	i := newCompactionIter(newMemtableIterator(m), d.cmp, smallestSnapshot, nil)
+	for ok := i.First(); ok; ok = i.Next() {
+		if err = b.add(i.Key(), i.Value()); err != nil {
+			return nil, errors.Join(err, b.abort())
 		}
 	}
-	return nil
+	if err = b.finish(); err != nil {
+		return nil, errors.Join(err, b.abort())
+	}
+	return b.meta, nil
 }
 
 func (d *DB) Get(key []byte) ([]byte, error) {
+	return d.get(key, nil)
+}
+
+// readState is a consistent view of the database: the memtables and the version of the LSM tree that together hold
+// every write up to and including sequence number seqNum.
+type readState struct {
+	seqNum    uint64
+	memtables []*memtable.Memtable
+	version   *version // pinned until the reader calls unrefVersion
+}
+
+// loadReadState captures the state read by Get and NewIterator, or by those of snapshot "s" if it is not nil. The
+// sequence number, memtables and version are captured in a single critical section, so that no flush or compaction
+// can come in between and drop the versions of keys visible at the sequence number.
+func (d *DB) loadReadState(s *Snapshot) (*readState, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	seqNum := d.seqNum
+	if s != nil {
+		if s.elem == nil {
+			return nil, ErrSnapshotReleased
+		}
+		seqNum = s.seqNum
+	}
+	return &readState{seqNum: seqNum, memtables: slices.Clone(d.memtables.queue), version: d.refVersion()}, nil
+}
+
+// get returns the value of the most recent version of "key" visible to snapshot "s", or to a new read if it is nil.
+func (d *DB) get(key []byte, s *Snapshot) ([]byte, error) {
+	rs, err := d.loadReadState(s)
+	if err != nil {
+		return nil, err
+	}
+	defer d.unrefVersion(rs.version)
+
 	// Scan memtables from newest to oldest.
-	for i := len(d.memtables.queue) - 1; i >= 0; i-- {
-		m := d.memtables.queue[i]
-		encodedValue, err := m.Get(key)
+	for i := len(rs.memtables) - 1; i >= 0; i-- {
+		m := rs.memtables[i]
+		encodedValue, err := m.Get(key, rs.seqNum)
 		if err != nil {
 			continue // The only possible error is "key not found".
 		}
@@ -251,26 +720,14 @@ func (d *DB) Get(key []byte) ([]byte, error) {
 		log.Printf(`Found key "%s" in memtable "%d" with value "%s"`, key, i, encodedValue.Value())
 		return encodedValue.Value(), nil
 	}
-	// Scan sstables from newest to oldest.
-	for j := len(d.sstables) - 1; j >= 0; j-- {
-		meta := d.sstables[j]
-		f, err := d.dataStorage.OpenFileForReading(meta)
-		if err != nil {
-			return nil, err
-		}
-		r, err := sstable.NewReader(f)
-		if err != nil {
-			return nil, err
-		}
-		defer r.Close()
-
-		var encodedValue *encoder.EncodedValue
-		encodedValue, err = r.Get(key)
+	// Scan the sstables whose key ranges contain the key from newest to oldest.
+	for _, meta := range rs.version.tablesForKey(key) {
+		encodedValue, err := d.tableCache.get(meta, key, rs.seqNum)
 		if err != nil {
 			if errors.Is(err, sstable.ErrKeyNotFound) {
 				continue
 			}
-			log.Fatal(err)
+			return nil, err
 		}
 		if encodedValue.IsTombstone() {
 			log.Printf(`Found key "%s" marked as deleted in sstable "%d".`, key, meta.FileNum())
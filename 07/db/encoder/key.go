@@ -0,0 +1,48 @@
+package encoder
+
+import (
+	"cmp"
+	"encoding/binary"
+	"math"
+)
+
+// SeqNumSize is the length of the trailer holding the sequence number at the end of each internal key.
+const SeqNumSize = 8
+
+// MaxSeqNum sorts before every other sequence number, so it can be used for seeking to the newest version of a key.
+const MaxSeqNum = math.MaxUint64
+
+// EncodeKey forms an internal key by appending "seqNum" to the user key.
+func (e *Encoder) EncodeKey(key []byte, seqNum uint64) []byte {
+	n := len(key)
+	buf := make([]byte, n+SeqNumSize)
+	copy(buf, key)
+	binary.LittleEndian.PutUint64(buf[n:], seqNum)
+	return buf
+}
+
+// AppendSeqNum forms an internal key by appending "seqNum" to "dst", which holds the user key.
+func AppendSeqNum(dst []byte, seqNum uint64) []byte {
+	return binary.LittleEndian.AppendUint64(dst, seqNum)
+}
+
+// UserKey strips the sequence number from an internal key.
+func UserKey(key []byte) []byte {
+	return key[:len(key)-SeqNumSize]
+}
+
+// SeqNum extracts the sequence number from an internal key.
+func SeqNum(key []byte) uint64 {
+	return binary.LittleEndian.Uint64(key[len(key)-SeqNumSize:])
+}
+
+// KeyComparer returns a function that orders internal keys by user key according to "compareUserKeys" and, for equal
+// user keys, by sequence number in descending order, so that the most recent version of a key always comes first.
+func KeyComparer(compareUserKeys func(a, b []byte) int) func(a, b []byte) int {
+	return func(a, b []byte) int {
+		if c := compareUserKeys(UserKey(a), UserKey(b)); c != 0 {
+			return c
+		}
+		return cmp.Compare(SeqNum(b), SeqNum(a))
+	}
+}
@@ -0,0 +1,328 @@
+package db
+
+import (
+	"container/heap"
+	"errors"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/memtable"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/storage"
+)
+
+// internalIterator is implemented by every source of key-value pairs taking part in a merge (memtables and sstables).
+// Keys are returned as internal keys carrying their sequence number, and values are returned in their encoded form,
+// so that tombstones can be told apart from regular values.
+type internalIterator interface {
+	First() bool
+	SeekGE(key []byte) bool
+	Next() bool
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Close() error
+}
+
+// memtableIterator adapts a memtable.Iterator to the internalIterator interface.
+type memtableIterator struct {
+	m     *memtable.Memtable
+	iter  *memtable.Iterator
+	key   []byte
+	val   []byte
+	valid bool
+}
+
+func newMemtableIterator(m *memtable.Memtable) *memtableIterator {
+	return &memtableIterator{m: m}
+}
+
+func (i *memtableIterator) First() bool {
+	i.iter = i.m.Iterator()
+	return i.Next()
+}
+
+func (i *memtableIterator) SeekGE(key []byte) bool {
+	i.iter = i.m.Iterator()
+	i.iter.Seek(key)
+	return i.Next()
+}
+
+func (i *memtableIterator) Next() bool {
+	i.valid = i.iter.HasNext()
+	if !i.valid {
+		i.key, i.val = nil, nil
+		return false
+	}
+	i.key, i.val = i.iter.Next()
+	return true
+}
+
+func (i *memtableIterator) Valid() bool   { return i.valid }
+func (i *memtableIterator) Key() []byte   { return i.key }
+func (i *memtableIterator) Value() []byte { return i.val }
+func (i *memtableIterator) Error() error  { return nil }
+func (i *memtableIterator) Close() error  { return nil }
+
+// mergingHeap orders the positioned iterators by their current internal key. Ties (which only occur when a WAL was
+// replayed twice) are broken in favour of the iterator with the lowest index.
+type mergingHeap struct {
+	iters       []internalIterator
+	index       []int
+	compareKeys func(a, b []byte) int
+}
+
+func (h *mergingHeap) Len() int { return len(h.index) }
+
+func (h *mergingHeap) Less(a, b int) bool {
+	cmp := h.compareKeys(h.iters[h.index[a]].Key(), h.iters[h.index[b]].Key())
+	if cmp == 0 {
+		return h.index[a] < h.index[b]
+	}
+	return cmp < 0
+}
+
+func (h *mergingHeap) Swap(a, b int) { h.index[a], h.index[b] = h.index[b], h.index[a] }
+
+func (h *mergingHeap) Push(x any) { h.index = append(h.index, x.(int)) }
+
+func (h *mergingHeap) Pop() any {
+	n := len(h.index) - 1
+	x := h.index[n]
+	h.index = h.index[:n]
+	return x
+}
+
+// mergingIter performs a k-way merge over several internal iterators, yielding every version of every key in
+// internal key order (i.e., the versions of a key from the most to the least recent one).
+type mergingIter struct {
+	heap  mergingHeap
+	valid bool
+	err   error
+}
+
+// newMergingIter merges "iters", which must be ordered from the most to the least recent data. Internal keys are
+// ordered by "compareKeys".
+func newMergingIter(compareKeys func(a, b []byte) int, iters []internalIterator) *mergingIter {
+	return &mergingIter{heap: mergingHeap{iters: iters, compareKeys: compareKeys}}
+}
+
+func (m *mergingIter) First() bool {
+	return m.init(func(iter internalIterator) bool { return iter.First() })
+}
+
+func (m *mergingIter) SeekGE(key []byte) bool {
+	return m.init(func(iter internalIterator) bool { return iter.SeekGE(key) })
+}
+
+// init positions every child iterator using "position" and rebuilds the heap.
+func (m *mergingIter) init(position func(iter internalIterator) bool) bool {
+	h := &m.heap
+	h.index = h.index[:0]
+	for idx, iter := range h.iters {
+		if !position(iter) {
+			if err := iter.Error(); err != nil {
+				return m.fail(err)
+			}
+			continue
+		}
+		h.index = append(h.index, idx)
+	}
+	heap.Init(h)
+	m.valid = h.Len() > 0
+	return m.valid
+}
+
+// Next advances the iterator holding the smallest key and restores the heap order.
+func (m *mergingIter) Next() bool {
+	h := &m.heap
+	if !m.valid {
+		return false
+	}
+	iter := h.iters[h.index[0]]
+	if iter.Next() {
+		heap.Fix(h, 0)
+	} else {
+		if err := iter.Error(); err != nil {
+			return m.fail(err)
+		}
+		heap.Pop(h)
+	}
+	m.valid = h.Len() > 0
+	return m.valid
+}
+
+func (m *mergingIter) fail(err error) bool {
+	m.err = err
+	m.valid = false
+	return false
+}
+
+func (m *mergingIter) Valid() bool  { return m.valid }
+func (m *mergingIter) Error() error { return m.err }
+
+func (m *mergingIter) Key() []byte {
+	return m.heap.iters[m.heap.index[0]].Key()
+}
+
+func (m *mergingIter) Value() []byte {
+	return m.heap.iters[m.heap.index[0]].Value()
+}
+
+func (m *mergingIter) Close() error {
+	m.valid = false
+	err := closeAll(m.heap.iters)
+	m.heap.iters, m.heap.index = nil, nil
+	return err
+}
+
+func closeAll(iters []internalIterator) (err error) {
+	for _, iter := range iters {
+		err = errors.Join(err, iter.Close())
+	}
+	return err
+}
+
+// Iterator exposes the most recent version of every key within [lower, upper) as of a given sequence number, merged
+// across all memtables and sstables. Keys marked as deleted are skipped.
+type Iterator struct {
+	db      *DB
+	version *version // version whose tables the iterator reads, pinned until Close
+	iter    *mergingIter
+	seqNum  uint64 // versions with a higher sequence number are invisible to the iterator
+	lower   []byte
+	upper   []byte
+	key     []byte
+	val     []byte
+	hasKey  bool
+	valid   bool
+
+	encoder *encoder.Encoder
+}
+
+// NewIterator returns an iterator over the key range [lower, upper). A nil bound leaves that side of the range open.
+// The iterator must be positioned with First before use and released with Close afterwards. Writes applied after
+// the iterator was created are not visible through it.
+func (d *DB) NewIterator(lower, upper []byte) (*Iterator, error) {
+	return d.newIterator(lower, upper, nil)
+}
+
+func (d *DB) newIterator(lower, upper []byte, s *Snapshot) (*Iterator, error) {
+	var iters []internalIterator
+
+	rs, err := d.loadReadState(s)
+	if err != nil {
+		return nil, err
+	}
+	memtables, v := rs.memtables, rs.version
+
+	// Register memtables from newest to oldest.
+	for i := len(memtables) - 1; i >= 0; i-- {
+		iters = append(iters, newMemtableIterator(memtables[i]))
+	}
+	// Register the sstables whose key ranges intersect the bounds from newest to oldest.
+	for _, meta := range v.tablesInRange(lower, upper) {
+		iter, err := d.newTableIterator(meta)
+		if err != nil {
+			closeAll(iters)
+			d.unrefVersion(v)
+			return nil, err
+		}
+		iters = append(iters, iter)
+	}
+
+	i := &Iterator{
+		db:      d,
+		version: v,
+		iter:    newMergingIter(d.compareKeys, iters),
+		seqNum:  rs.seqNum,
+		lower:   lower,
+		upper:   upper,
+		encoder: encoder.NewEncoder(),
+	}
+	return i, nil
+}
+
+// newTableIterator returns an iterator over the *.sst file described by "meta".
+func (d *DB) newTableIterator(meta *storage.FileMetadata) (*tableIterator, error) {
+	return d.tableCache.newIterator(meta)
+}
+
+// First moves the iterator to the smallest live key within its bounds.
+func (i *Iterator) First() bool {
+	var ok bool
+	if i.lower != nil {
+		ok = i.iter.SeekGE(i.encoder.EncodeKey(i.lower, encoder.MaxSeqNum))
+	} else {
+		ok = i.iter.First()
+	}
+	i.hasKey = false
+	return i.findNextEntry(ok)
+}
+
+// Next advances the iterator to the next live key within its bounds.
+func (i *Iterator) Next() bool {
+	if !i.valid {
+		return false
+	}
+	return i.findNextEntry(i.iter.Next())
+}
+
+// findNextEntry settles on the most recent visible version of the next key, skipping over tombstones and the older
+// versions of keys that were already considered.
+func (i *Iterator) findNextEntry(ok bool) bool {
+	for ; ok; ok = i.iter.Next() {
+		key := i.iter.Key()
+		if encoder.SeqNum(key) > i.seqNum {
+			continue
+		}
+		userKey := encoder.UserKey(key)
+		if i.hasKey && i.db.cmp.Compare(userKey, i.key) == 0 {
+			continue
+		}
+		if i.upper != nil && i.db.cmp.Compare(userKey, i.upper) >= 0 {
+			break
+		}
+		i.key = append(i.key[:0], userKey...)
+		i.hasKey = true
+		value := i.encoder.Parse(i.iter.Value())
+		if value.IsTombstone() {
+			continue
+		}
+		i.val = value.Value()
+		i.valid = true
+		return true
+	}
+	i.valid = false
+	return false
+}
+
+// Valid reports whether the iterator is positioned at a key-value pair.
+func (i *Iterator) Valid() bool {
+	return i.valid
+}
+
+// Key returns the key at the current position. It remains valid only until the next call to Next.
+func (i *Iterator) Key() []byte {
+	return i.key
+}
+
+// Value returns the value at the current position.
+func (i *Iterator) Value() []byte {
+	return i.val
+}
+
+// Error returns the first error encountered while iterating, if any.
+func (i *Iterator) Error() error {
+	return i.iter.Error()
+}
+
+// Close releases all files held open by the iterator.
+func (i *Iterator) Close() error {
+	i.valid = false
+	err := i.iter.Close()
+	if i.version != nil {
+		i.db.unrefVersion(i.version)
+		i.version = nil
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+// flush writes the memtables to level 0, as if they had filled up, and waits for any compaction this triggers.
+func flush(t *testing.T, d *DB) {
+	t.Helper()
+	waitForBackgroundWork(d)
+	d.mu.Lock()
+	err := d.rotateWAL()
+	if err == nil {
+		d.rotateMemtables(0)
+		err = d.flushMemtables()
+	}
+	d.maybeScheduleBackgroundWork()
+	d.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForBackgroundWork(d)
+}
+
+// rotate turns the mutable memtable into an immutable one, without flushing it.
+func rotate(t *testing.T, d *DB) {
+	t.Helper()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.rotateWAL(); err != nil {
+		t.Fatal(err)
+	}
+	d.rotateMemtables(0)
+}
+
+// scan returns the keys and values within [lower, upper) as "key=value" pairs.
+func scan(t *testing.T, d *DB, lower, upper []byte) []string {
+	t.Helper()
+	iter, err := d.NewIterator(lower, upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pairs []string
+	for ok := iter.First(); ok; ok = iter.Next() {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+	}
+	if err = iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if err = iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return pairs
+}
+
+// TestIterator spreads the versions of the keys over level 1, level 0, an immutable and the mutable memtable, with
+// tombstones in every layer above level 1, and compares what iterators see with a model of the expected contents.
+func TestIterator(t *testing.T) {
+	discardLogs(t)
+	d, err := Open("db", &Options{FS: vfs.NewMem(), L0CompactionTrigger: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	model := make(map[string]string)
+	set := func(val string, from, to int) {
+		for k := from; k <= to; k++ {
+			key := fmt.Sprintf("k%02d", k)
+			if err := d.Set([]byte(key), []byte(val)); err != nil {
+				t.Fatal(err)
+			}
+			model[key] = val
+		}
+	}
+	del := func(k int) {
+		key := fmt.Sprintf("k%02d", k)
+		if err := d.Delete([]byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		delete(model, key)
+	}
+
+	// two flushes reach the compaction trigger, so that their tables end up in level 1
+	set("A", 0, 19)
+	flush(t, d)
+	set("B", 10, 19)
+	del(15)
+	flush(t, d)
+	// level 0
+	set("C", 5, 7)
+	del(0)
+	set("C", 15, 15)
+	flush(t, d)
+	// immutable memtable
+	set("D", 6, 6)
+	del(16)
+	set("D", 20, 20)
+	rotate(t, d)
+	// mutable memtable
+	del(5)
+	set("E", 21, 21)
+
+	levels := levelFileNums(d)
+	if len(levels[0]) != 1 || len(levels[1]) == 0 {
+		t.Fatalf("got levels %v, want one table in level 0 and at least one in level 1", levels)
+	}
+	if n := len(d.memtables.queue); n != 2 {
+		t.Fatalf("got %d memtables, want 2", n)
+	}
+
+	tests := []struct {
+		name         string
+		lower, upper string
+	}{
+		{"unbounded", "", ""},
+		{"lower bound", "k15", ""},
+		{"upper bound", "", "k07"},
+		{"both bounds", "k05", "k17"},
+		{"bounds between keys", "k055", "k161"},
+		{"bounds on deleted keys", "k00", "k16"},
+		{"empty range", "k10", "k10"},
+		{"range before all keys", "", "k"},
+		{"range after all keys", "k3", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lower, upper []byte
+			if tt.lower != "" {
+				lower = []byte(tt.lower)
+			}
+			if tt.upper != "" {
+				upper = []byte(tt.upper)
+			}
+			var want []string
+			for key, val := range model {
+				if key >= tt.lower && (upper == nil || key < tt.upper) {
+					want = append(want, fmt.Sprintf("%s=%s", key, val))
+				}
+			}
+			slices.Sort(want)
+			if got := scan(t, d, lower, upper); !slices.Equal(got, want) {
+				t.Fatalf("got  %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestIteratorIgnoresLaterWrites(t *testing.T) {
+	discardLogs(t)
+	d, err := Open("db", &Options{FS: vfs.NewMem()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		if err = d.Set([]byte(key), []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iter, err := d.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	if err = d.Set([]byte("b"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Delete([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Set([]byte("d"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for ok := iter.First(); ok; ok = iter.Next() {
+		got = append(got, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+	}
+	if want := []string{"a=old", "b=old", "c=old"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,26 @@
+package memtable
+
+import "github.com/cloudcentricdev/golang-tutorials/07/db/skiplist"
+
+// Iterator walks the entries of a Memtable in ascending key order. It remains usable while entries are being inserted
+// into the Memtable, and may or may not observe entries inserted after it was created.
+type Iterator struct {
+	iter *skiplist.Iterator
+}
+
+func (m *Memtable) Iterator() *Iterator {
+	return &Iterator{iter: m.sl.Iterator()}
+}
+
+// Seek positions the iterator so that the subsequent call to Next returns the first entry greater than or equal to "key".
+func (i *Iterator) Seek(key []byte) {
+	i.iter.Seek(key)
+}
+
+func (i *Iterator) HasNext() bool {
+	return i.iter.HasNext()
+}
+
+func (i *Iterator) Next() ([]byte, []byte) {
+	return i.iter.Next()
+}
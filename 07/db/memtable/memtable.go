@@ -1,63 +1,106 @@
 package memtable
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/skiplist"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/storage"
 )
 
+// MaxSizeLimit is the largest size limit of a Memtable, as its arena is addressed by uint32 offsets.
+const MaxSizeLimit = 1 << 31 // 2 GiB
+
+// Memtable is safe for concurrent use by any number of writers and readers, as it is backed by a lock-free skip list.
+// Its entries are stored in an arena of fixed size, so the Memtable is full exactly when the arena is exhausted.
 type Memtable struct {
-	sl        *skiplist.SkipList
-	sizeUsed  int // The approximate amount of space used by the Memtable so far (in bytes).
-	sizeLimit int // The maximum allowed size of the Memtable (in bytes).
-	encoder   *encoder.Encoder
-	logMeta   *storage.FileMetadata
+	arena   *skiplist.Arena
+	sl      *skiplist.SkipList
+	encoder *encoder.Encoder
+	logMeta *storage.FileMetadata
+	cmp     comparer.Comparer
 }
 
-func NewMemtable(sizeLimit int, logMeta *storage.FileMetadata) *Memtable {
+// NewMemtable creates an empty Memtable with room for "sizeLimit" bytes of entries, whose user keys are ordered by "cmp".
+func NewMemtable(sizeLimit int, logMeta *storage.FileMetadata, cmp comparer.Comparer) *Memtable {
 	m := &Memtable{
-		sl:        skiplist.NewSkipList(),
-		sizeLimit: sizeLimit,
-		encoder:   encoder.NewEncoder(),
-		logMeta:   logMeta,
+		arena:   skiplist.NewArena(sizeLimit + skiplist.EmptySize()),
+		cmp:     cmp,
+		encoder: encoder.NewEncoder(),
+		logMeta: logMeta,
+	}
+	sl, err := skiplist.NewSkipList(m.arena, encoder.KeyComparer(cmp.Compare))
+	if err != nil {
+		panic(err) // the arena was sized to hold the empty list
 	}
+	m.sl = sl
 	return m
 }
 
-func (m *Memtable) HasRoomForWrite(key, val []byte) bool {
-	sizeNeeded := len(key) + len(val) + 1
-	sizeAvailable := m.sizeLimit - m.sizeUsed
+// EntrySize returns the largest amount of space (in bytes) that a single write of "key" and "val" can use in a
+// Memtable. The exact amount depends on the height the entry is assigned in the skip list.
+func EntrySize(key, val []byte) int {
+	return skiplist.MaxNodeSize(len(key)+encoder.SeqNumSize, len(val)+1)
+}
 
-	if sizeNeeded > sizeAvailable {
-		return false
-	}
-	return true
+// HasRoomForWrite reports whether "sizeNeeded" more bytes fit into the Memtable.
+func (m *Memtable) HasRoomForWrite(sizeNeeded int) bool {
+	return m.arena.Capacity()-m.arena.Size() >= sizeNeeded
 }
 
-func (m *Memtable) Insert(key, val []byte) {
-	m.sl.Insert(key, m.encoder.Encode(encoder.OpKindSet, val))
-	m.sizeUsed += len(key) + len(val) + 1
+func (m *Memtable) Insert(key, val []byte, seqNum uint64) error {
+	return m.insert(encoder.OpKindSet, key, val, seqNum)
 }
 
-func (m *Memtable) InsertTombstone(key []byte) {
-	m.sl.Insert(key, m.encoder.Encode(encoder.OpKindDelete, nil))
-	m.sizeUsed += 1
+func (m *Memtable) InsertTombstone(key []byte, seqNum uint64) error {
+	return m.insert(encoder.OpKindDelete, key, nil, seqNum)
 }
 
-func (m *Memtable) Get(key []byte) (*encoder.EncodedValue, error) {
-	val, err := m.sl.Find(key)
-	if err != nil {
-		return nil, err
+// scratchBufs hold internal keys and encoded values until the skip list has copied them into its arena.
+var scratchBufs = sync.Pool{
+	New: func() any { return new([]byte) },
+}
+
+// insert encodes the internal key followed by the encoded value into a scratch buffer, from which the skip list
+// copies them into the arena.
+func (m *Memtable) insert(kind encoder.OpKind, key, val []byte, seqNum uint64) error {
+	scratch := scratchBufs.Get().(*[]byte)
+	defer scratchBufs.Put(scratch)
+	n := len(key) + encoder.SeqNumSize
+	buf := append((*scratch)[:0], key...)
+	buf = encoder.AppendSeqNum(buf, seqNum)
+	buf = append(buf, byte(kind))
+	buf = append(buf, val...)
+	*scratch = buf
+	err := m.sl.Insert(buf[:n], buf[n:])
+	if errors.Is(err, skiplist.ErrRecordExists) {
+		// every write is assigned sequence numbers of its own, so a duplicate points at a sequence number handed out
+		// twice or at a damaged WAL
+		return fmt.Errorf("memtable: key %q with sequence number %d inserted twice: %w", key, seqNum, err)
 	}
-	return m.encoder.Parse(val), nil
+	return err
 }
 
-func (m *Memtable) Iterator() *skiplist.Iterator {
-	return m.sl.Iterator()
+// Get returns the most recent version of "key" whose sequence number does not exceed "seqNum".
+func (m *Memtable) Get(key []byte, seqNum uint64) (*encoder.EncodedValue, error) {
+	i := m.sl.Iterator()
+	i.Seek(m.encoder.EncodeKey(key, seqNum))
+	if !i.HasNext() {
+		return nil, skiplist.ErrKeyNotFound
+	}
+	found, val := i.Next()
+	if m.cmp.Compare(encoder.UserKey(found), key) != 0 {
+		return nil, skiplist.ErrKeyNotFound
+	}
+	return m.encoder.Parse(val), nil
 }
 
+// Size returns the exact amount of space (in bytes) used by the entries of the Memtable.
 func (m *Memtable) Size() int {
-	return m.sizeUsed
+	return m.arena.Size() - skiplist.EmptySize()
 }
 
 func (m *Memtable) LogFile() *storage.FileMetadata {
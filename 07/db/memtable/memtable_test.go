@@ -0,0 +1,29 @@
+package memtable
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/skiplist"
+)
+
+func TestDuplicateInternalKey(t *testing.T) {
+	m := NewMemtable(1<<10, nil, comparer.Default)
+	if err := m.Insert([]byte("key"), []byte("v1"), 1); err != nil {
+		t.Fatal(err)
+	}
+	// a second write with the same sequence number must not be dropped silently
+	if err := m.Insert([]byte("key"), []byte("v2"), 1); !errors.Is(err, skiplist.ErrRecordExists) {
+		t.Fatalf("got %v, want ErrRecordExists", err)
+	}
+	if err := m.InsertTombstone([]byte("key"), 1); !errors.Is(err, skiplist.ErrRecordExists) {
+		t.Fatalf("got %v, want ErrRecordExists", err)
+	}
+	if err := m.Insert([]byte("key"), []byte("v2"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := m.Get([]byte("key"), 2); err != nil || string(v.Value()) != "v2" {
+		t.Fatalf("got %v (%v), want %q", v, err, "v2")
+	}
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/storage"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+const openTestKeys = 300
+
+// populate writes "openTestKeys" keys with small memtables, so that some of them end up in tables and the rest is left
+// in the WAL when the database is closed.
+func populate(t *testing.T, fs vfs.FS) *Options {
+	opts := &Options{FS: fs, MemtableSizeLimit: 1 << 10, MemtableFlushThreshold: 2 << 10}
+	d, err := Open("db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < openTestKeys; k++ {
+		if err = d.Set([]byte(fmt.Sprintf("key%03d", k)), []byte(fmt.Sprintf("val%03d", k))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func checkContents(t *testing.T, d *DB) {
+	t.Helper()
+	for k := 0; k < openTestKeys; k++ {
+		want := fmt.Sprintf("val%03d", k)
+		if got, err := d.Get([]byte(fmt.Sprintf("key%03d", k))); err != nil || string(got) != want {
+			t.Fatalf("key%03d: got %q (%v), want %q", k, got, err, want)
+		}
+	}
+}
+
+func listDir(t *testing.T, fs vfs.FS) []string {
+	t.Helper()
+	names, err := fs.List("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestReopen(t *testing.T) {
+	discardLogs(t)
+	for _, flushOnClose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("FlushOnClose=%v", flushOnClose), func(t *testing.T) {
+			fs := vfs.NewMem()
+			opts := populate(t, fs)
+			opts.FlushOnClose = flushOnClose
+			// every round replays what the previous one left behind
+			for round := 0; round < 3; round++ {
+				d, err := Open("db", opts)
+				if err != nil {
+					t.Fatalf("round %d: %v", round, err)
+				}
+				checkContents(t, d)
+				if err = d.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenWithoutManifest(t *testing.T) {
+	for _, name := range []string{"000004.sst", "000005.log"} {
+		t.Run(name, func(t *testing.T) {
+			fs := vfs.NewMem()
+			if err := fs.MkdirAll("db"); err != nil {
+				t.Fatal(err)
+			}
+			// the files of a database written without a MANIFEST must be left alone
+			rewriteFile(t, fs, filepath.Join("db", name), []byte("data"))
+			if _, err := Open("db", &Options{FS: fs}); !errors.Is(err, ErrMissingManifest) {
+				t.Fatalf("got %v, want ErrMissingManifest", err)
+			}
+			if got, want := listDir(t, fs), []string{name, "LOCK"}; !slices.Equal(got, want) {
+				t.Fatalf("got files %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// manifestPath returns the path of the MANIFEST file referenced by CURRENT.
+func manifestPath(t *testing.T, fs vfs.FS) string {
+	current, err := vfs.ReadFile(fs, filepath.Join("db", "CURRENT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join("db", strings.TrimSuffix(string(current), "\n"))
+}
+
+func TestManifestDamage(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name    string
+		damage  func(manifest []byte) []byte
+		wantErr error
+	}{
+		{"torn header", func(m []byte) []byte {
+			return append(m, 0x10, 0x00)
+		}, nil},
+		{"torn payload", func(m []byte) []byte {
+			return append(binary.LittleEndian.AppendUint32(m, 100), 1, 2, 3, 4, 5)
+		}, nil},
+		{"torn final record", func(m []byte) []byte {
+			// a record of the right length whose checksum does not match, as its payload was not written in full
+			return append(binary.LittleEndian.AppendUint32(m, 3), 0, 0, 0, 0, 1, 2, 3)
+		}, nil},
+		{"corrupted record", func(m []byte) []byte {
+			m[len(m)/2] ^= 0xff
+			return m
+		}, storage.ErrManifestCorrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := vfs.NewMem()
+			opts := populate(t, fs)
+			path := manifestPath(t, fs)
+			buf, err := vfs.ReadFile(fs, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rewriteFile(t, fs, path, tt.damage(buf))
+			before := listDir(t, fs)
+
+			d, err := Open("db", opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if after := listDir(t, fs); !slices.Equal(after, before) {
+					t.Fatalf("files changed by the failed Open:\nbefore %v\nafter  %v", before, after)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			checkContents(t, d)
+		})
+	}
+}
+
+func TestMissingTable(t *testing.T) {
+	discardLogs(t)
+	fs := vfs.NewMem()
+	opts := populate(t, fs)
+	names := listDir(t, fs)
+	i := slices.IndexFunc(names, func(name string) bool { return strings.HasSuffix(name, ".sst") })
+	if i < 0 {
+		t.Fatalf("no table among %v", names)
+	}
+	if err := fs.Remove(filepath.Join("db", names[i])); err != nil {
+		t.Fatal(err)
+	}
+	before := listDir(t, fs)
+	if _, err := Open("db", opts); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("got %v, want an error about the missing table", err)
+	}
+	// nothing is deleted by an Open that fails
+	if after := listDir(t, fs); !slices.Equal(after, before) {
+		t.Fatalf("files changed by the failed Open:\nbefore %v\nafter  %v", before, after)
+	}
+}
+
+func TestLock(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name string
+		fs   vfs.FS
+		dir  string
+	}{
+		{"mem", vfs.NewMem(), "db"},
+		{"os", vfs.Default, t.TempDir()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &Options{FS: tt.fs}
+			d, err := Open(tt.dir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = Open(tt.dir, opts); !errors.Is(err, ErrLocked) {
+				t.Fatalf("second Open: got %v, want ErrLocked", err)
+			}
+			// the failed attempt must not have released the lock of the open database
+			if _, err = Open(tt.dir, opts); !errors.Is(err, ErrLocked) {
+				t.Fatalf("third Open: got %v, want ErrLocked", err)
+			}
+			if err = d.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if d, err = Open(tt.dir, opts); err != nil {
+				t.Fatalf("Open after Close: %v", err)
+			}
+			if err = d.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLockReleasedByFailedOpen(t *testing.T) {
+	fs := vfs.NewMem()
+	if err := fs.MkdirAll("db"); err != nil {
+		t.Fatal(err)
+	}
+	rewriteFile(t, fs, filepath.Join("db", "000004.sst"), []byte("data"))
+	// an Open that fails after taking the lock gives it up again
+	for i := 0; i < 2; i++ {
+		if _, err := Open("db", &Options{FS: fs}); !errors.Is(err, ErrMissingManifest) {
+			t.Fatalf("attempt %d: got %v, want ErrMissingManifest", i, err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/memtable"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/sstable"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/wal"
+)
+
+var (
+	ErrInvalidOptions      = errors.New("invalid options")
+	ErrIncompatibleOptions = errors.New("options incompatible with existing database")
+)
+
+// WALRecoveryMode determines how Open deals with corrupted records found while replaying the WAL.
+type WALRecoveryMode int
+
+const (
+	// WALRecoveryStopAtCorruption replays the WAL up to the first corrupted record and drops everything after it,
+	// including any later WAL files. This tolerates a record torn by a crash at the tail of the WAL.
+	WALRecoveryStopAtCorruption WALRecoveryMode = iota
+	// WALRecoveryFail makes Open fail if any record of the WAL is corrupted.
+	WALRecoveryFail
+	// WALRecoverySkipCorrupted drops corrupted records and resumes the replay with the next valid record.
+	WALRecoverySkipCorrupted
+)
+
+const (
+	DefaultMemtableSizeLimit      = 4 << 10  // 4 KiB
+	DefaultMemtableFlushThreshold = 8 << 10  // 8 KiB
+	DefaultMemtableStallFactor    = 4        // multiple of the flush threshold
+	DefaultL0CompactionTrigger    = 4        // level 0 tables
+	DefaultBaseLevelSize          = 64 << 10 // 64 KiB
+	DefaultLevelSizeMultiplier    = 10
+	DefaultTargetFileSize         = 16 << 10 // 16 KiB
+	DefaultTableCacheSize         = 64       // open *.sst files
+	DefaultBlockCacheSize         = 8 << 20  // 8 MiB
+)
+
+// Options configures a database. A nil *Options, as well as any zero field, selects the defaults.
+type Options struct {
+	// Comparer defines the order of the keys, bytewise by default. Its name is recorded on disk, so a database must
+	// always be opened with the same comparer.
+	Comparer comparer.Comparer
+
+	// MemtableSizeLimit is the capacity (in bytes) of the arena holding the entries of a memtable. The mutable memtable
+	// is rotated out once its arena has no room left for the next write.
+	MemtableSizeLimit int
+	// MemtableFlushThreshold is the total size (in bytes) of all memtables at which the immutable ones get flushed.
+	// It must not be smaller than MemtableSizeLimit.
+	MemtableFlushThreshold int
+	// MemtableStallFactor stalls writes once the immutable memtables waiting to be flushed reach this multiple of
+	// MemtableFlushThreshold.
+	MemtableStallFactor int
+
+	// L0CompactionTrigger is the number of level 0 tables at which they get compacted into level 1.
+	L0CompactionTrigger int
+	// BaseLevelSize is the size (in bytes) level 1 may reach before its tables get compacted into level 2.
+	BaseLevelSize int
+	// LevelSizeMultiplier is the factor by which each level below level 1 may grow larger than the one above it.
+	LevelSizeMultiplier int
+	// TargetFileSize is the size (in bytes) at which the output of a compaction is split into another table.
+	TargetFileSize int
+
+	// MaxBlockSize is the expected maximum size (in bytes) of an uncompressed sstable data block.
+	MaxBlockSize int
+	// BlockFlushThreshold is the size (in bytes) at which an sstable data block is completed. It defaults to 90% of
+	// MaxBlockSize and must not exceed it.
+	BlockFlushThreshold int
+	// DataBlockChunkSize is the number of entries per prefix-compressed chunk of a data block.
+	DataBlockChunkSize int
+	// Compression selects the codec applied to sstable data blocks, snappy by default. The codec is recorded next to
+	// every block, so it can be changed between runs.
+	Compression sstable.Compression
+	// MinCompressionSavings is the fraction of its size a data block must shrink by for it to be stored compressed.
+	// Blocks that don't compress well enough are stored uncompressed. It must be below 1. Nil selects the default of
+	// 12.5%, whereas 0 stores every block compressed that shrinks at all.
+	MinCompressionSavings *float64
+
+	// TableCacheSize is the number of *.sst files kept open.
+	TableCacheSize int
+	// BlockCacheSize is the capacity (in bytes) of the cache for decompressed data blocks.
+	BlockCacheSize int
+
+	// WALBlockSize is the size (in bytes) of the blocks the WAL is split into. It is recorded on disk and cannot be
+	// changed for an existing database.
+	WALBlockSize    int
+	WALRecoveryMode WALRecoveryMode
+	WALSyncPolicy   wal.SyncPolicy // defaults to syncing on every write, see also WriteOptions.Sync
+
+	// FlushOnClose makes Close flush the memtables to level 0 tables, rather than leaving their contents to be
+	// replayed from the WAL the next time the database is opened.
+	FlushOnClose bool
+
+	// FS is the file system holding the data directory, vfs.Default by default. vfs.NewMem runs the database entirely
+	// in memory.
+	FS vfs.FS
+}
+
+// WriteOptions configures a single call to Apply. A nil *WriteOptions selects the defaults.
+type WriteOptions struct {
+	// Sync makes Apply sync the WAL before returning, whatever the WAL sync policy of the database.
+	Sync bool
+}
+
+func (o *Options) withDefaults() *Options {
+	opts := &Options{}
+	if o != nil {
+		*opts = *o
+	}
+	if opts.Comparer == nil {
+		opts.Comparer = comparer.Default
+	}
+	if opts.MemtableSizeLimit == 0 {
+		opts.MemtableSizeLimit = DefaultMemtableSizeLimit
+	}
+	if opts.MemtableFlushThreshold == 0 {
+		opts.MemtableFlushThreshold = max(DefaultMemtableFlushThreshold, opts.MemtableSizeLimit)
+	}
+	if opts.MemtableStallFactor == 0 {
+		opts.MemtableStallFactor = DefaultMemtableStallFactor
+	}
+	if opts.L0CompactionTrigger == 0 {
+		opts.L0CompactionTrigger = DefaultL0CompactionTrigger
+	}
+	if opts.BaseLevelSize == 0 {
+		opts.BaseLevelSize = DefaultBaseLevelSize
+	}
+	if opts.LevelSizeMultiplier == 0 {
+		opts.LevelSizeMultiplier = DefaultLevelSizeMultiplier
+	}
+	if opts.TargetFileSize == 0 {
+		opts.TargetFileSize = DefaultTargetFileSize
+	}
+	if opts.MaxBlockSize == 0 {
+		opts.MaxBlockSize = sstable.DefaultMaxBlockSize
+	}
+	if opts.BlockFlushThreshold == 0 {
+		opts.BlockFlushThreshold = sstable.DefaultBlockFlushThreshold(opts.MaxBlockSize)
+	}
+	if opts.DataBlockChunkSize == 0 {
+		opts.DataBlockChunkSize = sstable.DefaultDataBlockChunkSize
+	}
+	if opts.Compression == sstable.DefaultCompression {
+		opts.Compression = sstable.SnappyCompression
+	}
+	if opts.MinCompressionSavings == nil {
+		minSavings := sstable.DefaultMinCompressionSavings
+		opts.MinCompressionSavings = &minSavings
+	}
+	if opts.TableCacheSize == 0 {
+		opts.TableCacheSize = DefaultTableCacheSize
+	}
+	if opts.BlockCacheSize == 0 {
+		opts.BlockCacheSize = DefaultBlockCacheSize
+	}
+	if opts.WALBlockSize == 0 {
+		opts.WALBlockSize = wal.DefaultBlockSize
+	}
+	if opts.FS == nil {
+		opts.FS = vfs.Default
+	}
+	return opts
+}
+
+// validate reports the first option holding an unusable value. It expects the defaults to be filled in.
+func (o *Options) validate() error {
+	switch {
+	case o.MemtableSizeLimit < 0 || o.MemtableSizeLimit > memtable.MaxSizeLimit:
+		return invalidOption("MemtableSizeLimit", o.MemtableSizeLimit, "must be positive and not exceed 2 GiB")
+	case o.MemtableFlushThreshold < o.MemtableSizeLimit:
+		return invalidOption("MemtableFlushThreshold", o.MemtableFlushThreshold, "must not be smaller than MemtableSizeLimit")
+	case o.MemtableStallFactor < 0:
+		return invalidOption("MemtableStallFactor", o.MemtableStallFactor, "must be positive")
+	case o.L0CompactionTrigger < 0:
+		return invalidOption("L0CompactionTrigger", o.L0CompactionTrigger, "must be positive")
+	case o.BaseLevelSize < 0:
+		return invalidOption("BaseLevelSize", o.BaseLevelSize, "must be positive")
+	case o.LevelSizeMultiplier < 0 || o.LevelSizeMultiplier == 1:
+		return invalidOption("LevelSizeMultiplier", o.LevelSizeMultiplier, "must be at least 2")
+	case o.TargetFileSize < 0:
+		return invalidOption("TargetFileSize", o.TargetFileSize, "must be positive")
+	case o.MaxBlockSize < 0:
+		return invalidOption("MaxBlockSize", o.MaxBlockSize, "must be positive")
+	case o.BlockFlushThreshold < 0 || o.BlockFlushThreshold > o.MaxBlockSize:
+		return invalidOption("BlockFlushThreshold", o.BlockFlushThreshold, "must be positive and not exceed MaxBlockSize")
+	case o.DataBlockChunkSize < 0:
+		return invalidOption("DataBlockChunkSize", o.DataBlockChunkSize, "must be positive")
+	case *o.MinCompressionSavings < 0 || *o.MinCompressionSavings >= 1:
+		return invalidOption("MinCompressionSavings", *o.MinCompressionSavings, "must be between 0 and 1")
+	case o.TableCacheSize < 0:
+		return invalidOption("TableCacheSize", o.TableCacheSize, "must be positive")
+	case o.BlockCacheSize < 0:
+		return invalidOption("BlockCacheSize", o.BlockCacheSize, "must be positive")
+	case o.WALBlockSize < wal.MinBlockSize || o.WALBlockSize > wal.MaxBlockSize:
+		return invalidOption("WALBlockSize", o.WALBlockSize,
+			fmt.Sprintf("must be between %d and %d", wal.MinBlockSize, wal.MaxBlockSize))
+	case !knownCompression(o.Compression):
+		return invalidOption("Compression", o.Compression, "has no registered codec")
+	case o.WALRecoveryMode < WALRecoveryStopAtCorruption || o.WALRecoveryMode > WALRecoverySkipCorrupted:
+		return invalidOption("WALRecoveryMode", o.WALRecoveryMode, "unknown recovery mode")
+	case o.WALSyncPolicy.Mode < wal.SyncEveryWrite || o.WALSyncPolicy.Mode > wal.SyncNever:
+		return invalidOption("WALSyncPolicy.Mode", o.WALSyncPolicy.Mode, "unknown sync mode")
+	case o.WALSyncPolicy.Bytes < 0:
+		return invalidOption("WALSyncPolicy.Bytes", o.WALSyncPolicy.Bytes, "must not be negative")
+	case o.WALSyncPolicy.Interval < 0:
+		return invalidOption("WALSyncPolicy.Interval", o.WALSyncPolicy.Interval, "must not be negative")
+	case o.WALSyncPolicy.Mode == wal.SyncPeriodically && o.WALSyncPolicy.Bytes == 0 && o.WALSyncPolicy.Interval == 0:
+		return invalidOption("WALSyncPolicy", o.WALSyncPolicy, "must limit the unsynced bytes or the interval with SyncPeriodically")
+	}
+	return nil
+}
+
+func knownCompression(c sstable.Compression) bool {
+	_, err := sstable.LookupCodec(c)
+	return err == nil
+}
+
+func invalidOption(name string, val any, reason string) error {
+	return fmt.Errorf("%w: %s = %v %s", ErrInvalidOptions, name, val, reason)
+}
+
+func (o *Options) writerOptions() sstable.WriterOptions {
+	return sstable.WriterOptions{
+		MaxBlockSize:        o.MaxBlockSize,
+		BlockFlushThreshold: o.BlockFlushThreshold,
+		DataBlockChunkSize:  o.DataBlockChunkSize,
+
+		Compression:           o.Compression,
+		MinCompressionSavings: o.MinCompressionSavings,
+
+		Comparer: o.Comparer,
+	}
+}
+
+// formatSettings returns the options that determine how existing files must be read. They are recorded in the
+// OPTIONS file when a database is created.
+func (o *Options) formatSettings() map[string]string {
+	return map[string]string{
+		"comparer":       o.Comparer.Name(),
+		"wal_block_size": strconv.Itoa(o.WALBlockSize),
+	}
+}
+
+// checkOptions records the format settings of a new database, or makes sure that those of an existing database match.
+func (d *DB) checkOptions() error {
+	want := d.opts.formatSettings()
+	got, err := d.dataStorage.ReadOptions()
+	if err != nil {
+		return err
+	}
+	if got == nil {
+		return d.dataStorage.WriteOptions(want)
+	}
+	defaults := (*Options)(nil).withDefaults().formatSettings()
+	for name, val := range want {
+		onDisk, ok := got[name]
+		if !ok {
+			onDisk = defaults[name] // recorded before the setting existed, so the database uses the default
+		}
+		if onDisk != val {
+			return fmt.Errorf("%w: %s is %q on disk but %q was requested", ErrIncompatibleOptions, name, onDisk, val)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/sstable"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+func TestOptionsValidation(t *testing.T) {
+	savings := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name  string
+		opts  Options
+		valid bool
+	}{
+		{"defaults", Options{}, true},
+		{"negative memtable size limit", Options{MemtableSizeLimit: -1}, false},
+		{"flush threshold below memtable size limit", Options{MemtableSizeLimit: 8 << 10, MemtableFlushThreshold: 4 << 10}, false},
+		{"negative stall factor", Options{MemtableStallFactor: -1}, false},
+		{"negative L0 compaction trigger", Options{L0CompactionTrigger: -1}, false},
+		{"negative base level size", Options{BaseLevelSize: -1}, false},
+		{"level size multiplier of 1", Options{LevelSizeMultiplier: 1}, false},
+		{"level size multiplier of 2", Options{LevelSizeMultiplier: 2}, true},
+		{"negative target file size", Options{TargetFileSize: -1}, false},
+		{"negative block size", Options{MaxBlockSize: -1}, false},
+		{"flush threshold above block size", Options{MaxBlockSize: 1 << 10, BlockFlushThreshold: 2 << 10}, false},
+		{"unknown compression", Options{Compression: sstable.Compression(200)}, false},
+		{"no minimum compression savings", Options{MinCompressionSavings: savings(0)}, true},
+		{"negative minimum compression savings", Options{MinCompressionSavings: savings(-0.1)}, false},
+		{"minimum compression savings of 1", Options{MinCompressionSavings: savings(1)}, false},
+		{"negative table cache size", Options{TableCacheSize: -1}, false},
+		{"WAL block size too small", Options{WALBlockSize: 4}, false},
+		{"unknown WAL recovery mode", Options{WALRecoveryMode: WALRecoverySkipCorrupted + 1}, false},
+	}
+	for _, tt := range tests {
+		err := tt.opts.withDefaults().validate()
+		if (err == nil) != tt.valid || (err != nil && !errors.Is(err, ErrInvalidOptions)) {
+			t.Errorf("%s: got %v, want valid = %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestMinCompressionSavingsDefault(t *testing.T) {
+	if got := *(&Options{}).withDefaults().MinCompressionSavings; got != sstable.DefaultMinCompressionSavings {
+		t.Errorf("unset: got %v, want %v", got, sstable.DefaultMinCompressionSavings)
+	}
+	zero := 0.0
+	if got := *(&Options{MinCompressionSavings: &zero}).withDefaults().MinCompressionSavings; got != 0 {
+		t.Errorf("zero: got %v, want 0", got)
+	}
+}
+
+func TestL0CompactionTrigger(t *testing.T) {
+	discardLogs(t)
+	const trigger = 12
+	d, err := Open("db", &Options{
+		FS:                     vfs.NewMem(),
+		MemtableSizeLimit:      1 << 10,
+		MemtableFlushThreshold: 2 << 10,
+		L0CompactionTrigger:    trigger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	// level 0 must grow past the default trigger, but never reach the configured one
+	var maxL0 int
+	for k := 0; k < 300; k++ {
+		if err = d.Set([]byte(fmt.Sprintf("key%03d", k)), []byte("val")); err != nil {
+			t.Fatal(err)
+		}
+		waitForBackgroundWork(d)
+		maxL0 = max(maxL0, len(levelFileNums(d)[0]))
+	}
+	if maxL0 <= DefaultL0CompactionTrigger || maxL0 >= trigger {
+		t.Fatalf("level 0 held up to %d tables, want between %d and %d", maxL0, DefaultL0CompactionTrigger+1, trigger-1)
+	}
+	if levels := levelFileNums(d); len(levels[1]) == 0 {
+		t.Fatalf("level 0 was never compacted: %v", levels)
+	}
+}
+
+func TestIncompatibleReopen(t *testing.T) {
+	discardLogs(t)
+	fs := vfs.NewMem()
+	d, err := Open("db", &Options{FS: fs, WALBlockSize: 4 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open("db", &Options{FS: fs, WALBlockSize: 8 << 10}); !errors.Is(err, ErrIncompatibleOptions) {
+		t.Fatalf("got %v, want ErrIncompatibleOptions", err)
+	}
+	// settings that don't affect the format on disk may change between runs
+	if d, err = Open("db", &Options{FS: fs, WALBlockSize: 4 << 10, L0CompactionTrigger: 8, TargetFileSize: 4 << 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reverse orders keys in the reverse of the default order. It never shortens keys.
+type reverse struct{}
+
+func (reverse) Compare(a, b []byte) int           { return comparer.Default.Compare(b, a) }
+func (reverse) Name() string                      { return "reverse" }
+func (reverse) Separator(dst, a, b []byte) []byte { return append(dst, a...) }
+func (reverse) Successor(dst, a []byte) []byte    { return append(dst, a...) }
+
+func TestComparerMismatch(t *testing.T) {
+	discardLogs(t)
+	fs := vfs.NewMem()
+	opts := &Options{FS: fs, Comparer: reverse{}, FlushOnClose: true}
+	d, err := Open("db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err = d.Set([]byte(key), []byte("val")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmp := range []comparer.Comparer{nil, comparer.Default} {
+		if _, err = Open("db", &Options{FS: fs, Comparer: cmp}); !errors.Is(err, ErrIncompatibleOptions) {
+			t.Fatalf("comparer %v: got %v, want ErrIncompatibleOptions", cmp, err)
+		}
+	}
+	// the database is left intact by the rejected attempts
+	if d, err = Open("db", opts); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if got, want := scan(t, d, nil, nil), []string{"c=val", "b=val", "a=val"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
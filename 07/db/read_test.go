@@ -0,0 +1,118 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+// TestConcurrentReadsDuringFlushes has writers overwrite their keys round after round, with memtables small enough
+// that flushes and compactions run all the time, while readers check that Get never returns a value older than the
+// last acknowledged round and that iterators always see the complete batch of a single round.
+func TestConcurrentReadsDuringFlushes(t *testing.T) {
+	discardLogs(t)
+	d, err := Open("db", &Options{FS: vfs.NewMem(), MemtableSizeLimit: 1 << 10, MemtableFlushThreshold: 2 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	const numWriters, numKeys, numRounds = 4, 8, 150
+	key := func(w, k int) []byte { return []byte(fmt.Sprintf("w%d-k%d", w, k)) }
+	var acked [numWriters]atomic.Int64 // last round acknowledged to each writer, -1 before the first one
+	for w := range acked {
+		acked[w].Store(-1)
+	}
+	var done atomic.Bool
+	var writers, readers sync.WaitGroup
+	errs := make(chan error, numWriters+2)
+
+	for w := 0; w < numWriters; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for r := 0; r < numRounds; r++ {
+				var b Batch
+				for k := 0; k < numKeys; k++ {
+					b.Set(key(w, k), []byte(fmt.Sprintf("%04d", r)))
+				}
+				if err := d.Apply(&b, nil); err != nil {
+					errs <- err
+					return
+				}
+				acked[w].Store(int64(r))
+			}
+		}(w)
+	}
+
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		for i := 0; !done.Load(); i++ {
+			w, k := i%numWriters, i%numKeys
+			want := acked[w].Load()
+			if want < 0 {
+				continue
+			}
+			val, err := d.Get(key(w, k))
+			if err != nil {
+				errs <- fmt.Errorf("Get(%s) after round %d was acknowledged: %v", key(w, k), want, err)
+				return
+			}
+			if got := fmt.Sprintf("%04d", want); string(val) < got {
+				errs <- fmt.Errorf("Get(%s) = %s after round %s was acknowledged", key(w, k), val, got)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer readers.Done()
+		last := map[string]string{}
+		for !done.Load() {
+			rounds, err := scanRounds(d)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for w, r := range rounds {
+				if r < last[w] {
+					errs <- fmt.Errorf("iterator saw round %s of writer %s after round %s", r, w, last[w])
+					return
+				}
+				last[w] = r
+			}
+		}
+	}()
+
+	writers.Wait()
+	done.Store(true)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// scanRounds iterates over the database and returns the round of the keys of every writer, failing if the keys of a
+// writer belong to different rounds, i.e., the iterator saw only part of a batch.
+func scanRounds(d *DB) (map[string]string, error) {
+	iter, err := d.NewIterator(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	rounds := map[string]string{}
+	for ok := iter.First(); ok; ok = iter.Next() {
+		w, _, _ := strings.Cut(string(iter.Key()), "-")
+		if r, seen := rounds[w]; !seen {
+			rounds[w] = string(iter.Value())
+		} else if r != string(iter.Value()) {
+			return nil, fmt.Errorf("iterator saw rounds %s and %s of writer %s", r, iter.Value(), w)
+		}
+	}
+	return rounds, iter.Error()
+}
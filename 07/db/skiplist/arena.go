@@ -0,0 +1,76 @@
+package skiplist
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// alignment = 4 /* bytes */; bitmask = alignment - 1
+	bitmask = 3
+)
+
+// arenaReservedSize keeps offset 0 from being handed out.
+const arenaReservedSize = bitmask + 1
+
+var ErrArenaFull = errors.New("not enough memory")
+
+// Arena hands out memory from a single preallocated buffer. Allocations are aligned to 4 bytes and addressed by their
+// uint32 offset within the buffer, so the buffer holds no pointers for the garbage collector to trace. Offset 0 is
+// never handed out and serves as the nil offset. An Arena is safe for concurrent use.
+type Arena struct {
+	buffer   []byte
+	offset   atomic.Uint32 // next offset open for insertion
+	capacity uint32
+}
+
+// NewArena creates an Arena that can hand out "capacity" bytes, including alignment padding.
+func NewArena(capacity int) *Arena {
+	if capacity > math.MaxUint32-maxNodeSize {
+		panic("skiplist: arena capacity exceeds 4 GiB")
+	}
+	// the slack past the capacity lets a node of any height be addressed through *node, even though only the part of
+	// its tower in use is allocated
+	a := &Arena{
+		buffer:   make([]byte, capacity+maxNodeSize),
+		capacity: uint32(capacity),
+	}
+	a.offset.Store(arenaReservedSize)
+	return a
+}
+
+// Alloc reserves "dataSize" bytes and returns their offset.
+func (a *Arena) Alloc(dataSize int) (uint32, error) {
+	for {
+		currOffset := a.offset.Load()
+		nextOffset := (uint64(currOffset) + uint64(dataSize) + bitmask) &^ bitmask
+
+		if nextOffset > uint64(a.capacity) {
+			return 0, ErrArenaFull
+		}
+		// retry if a concurrent allocation got in first
+		if a.offset.CompareAndSwap(currOffset, uint32(nextOffset)) {
+			return currOffset, nil
+		}
+	}
+}
+
+// Size returns the number of bytes handed out so far, including alignment padding.
+func (a *Arena) Size() int {
+	return int(a.offset.Load())
+}
+
+// Capacity returns the number of bytes the Arena can hand out in total.
+func (a *Arena) Capacity() int {
+	return int(a.capacity)
+}
+
+func (a *Arena) bytes(offset, size uint32) []byte {
+	return a.buffer[offset : offset+size : offset+size]
+}
+
+func (a *Arena) pointer(offset uint32) unsafe.Pointer {
+	return unsafe.Pointer(&a.buffer[offset])
+}
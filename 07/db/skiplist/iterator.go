@@ -1,22 +1,34 @@
 package skiplist
 
+// Iterator walks the list in ascending key order. The keys and values it returns point into the arena and must not be
+// modified. An Iterator stays valid while nodes are inserted concurrently, and may or may not observe nodes inserted
+// after its position was established.
 type Iterator struct {
+	sl      *SkipList
 	current *node
+	next    uint32 // successor of current, loaded when current was reached
 }
 
 func (sl *SkipList) Iterator() *Iterator {
-	return &Iterator{sl.head}
+	head := sl.node(sl.head)
+	return &Iterator{sl, head, head.next(0)}
 }
 
 func (i *Iterator) HasNext() bool {
-	return i.current.tower[0] != nil
+	return i.next != 0
 }
 
 func (i *Iterator) Next() ([]byte, []byte) {
-	i.current = i.current.tower[0]
-
-	if i.current == nil {
+	if i.next == 0 {
 		return nil, nil
 	}
-	return i.current.key, i.current.val
+	i.current = i.sl.node(i.next)
+	i.next = i.current.next(0)
+	return i.sl.key(i.current), i.sl.val(i.current)
+}
+
+// Seek positions the iterator so that the subsequent call to Next returns the first key greater than or equal to "key".
+func (i *Iterator) Seek(key []byte) {
+	prev, next, _ := i.sl.search(key)
+	i.current, i.next = i.sl.node(prev), next
 }
@@ -1,9 +1,10 @@
 package skiplist
 
 import (
-	"bytes"
 	"errors"
 	"math"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/cloudcentricdev/golang-tutorials/03/fastrand"
 )
@@ -13,26 +14,60 @@ const (
 	PValue    = 0.5 // p = 1/2
 )
 
-var ErrKeyNotFound = errors.New("key not found")
+var (
+	ErrKeyNotFound  = errors.New("key not found")
+	ErrRecordExists = errors.New("record already exists")
+)
 
 var probabilities [MaxHeight]uint32
 
+// node is laid out in the arena, immediately followed by its key and value. Only the first "height" links of the
+// tower are allocated, so the key starts right after the last link in use. Links are arena offsets, 0 being nil, and
+// are only accessed atomically. The remaining fields, the key and the value are immutable once the node is linked.
 type node struct {
-	key   []byte
-	val   []byte
-	tower [MaxHeight]*node
+	keyOffset uint32
+	keySize   uint32
+	valSize   uint32
+	tower     [MaxHeight]uint32
 }
 
+const (
+	maxNodeSize = int(unsafe.Sizeof(node{}))
+	linkSize    = int(unsafe.Sizeof(uint32(0)))
+)
+
+// SkipList stores its nodes, keys, and values in an Arena. It is full once the Arena is exhausted. A SkipList is safe
+// for concurrent use by any number of readers and inserters without locking: nodes are linked into the list by
+// compare-and-swap operations on the links of their predecessors.
 type SkipList struct {
-	head   *node
-	height int
+	arena   *Arena
+	head    uint32
+	height  atomic.Int32
+	compare func(a, b []byte) int
+}
+
+// NewSkipList creates an empty skip list backed by "arena" whose keys are ordered by "compare". The empty list takes up
+// EmptySize() bytes of the arena.
+func NewSkipList(arena *Arena, compare func(a, b []byte) int) (*SkipList, error) {
+	sl := &SkipList{arena: arena, compare: compare}
+	head, err := sl.newNode(MaxHeight, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	sl.head = head
+	sl.height.Store(1)
+	return sl, nil
+}
+
+// EmptySize returns the amount of arena space (in bytes) taken up by an empty list.
+func EmptySize() int {
+	return arenaReservedSize + MaxNodeSize(0, 0)
 }
 
-func NewSkipList() *SkipList {
-	sl := &SkipList{}
-	sl.head = &node{}
-	sl.height = 1
-	return sl
+// MaxNodeSize returns the largest amount of arena space (in bytes) that a node holding a key of "keySize" bytes and a
+// value of "valSize" bytes can take up, including alignment padding.
+func MaxNodeSize(keySize, valSize int) int {
+	return (maxNodeSize + keySize + valSize + bitmask) &^ bitmask
 }
 
 func init() {
@@ -55,89 +90,144 @@ func randomHeight() int {
 	return height
 }
 
-func (sl *SkipList) search(key []byte) (*node, [MaxHeight]*node) {
-	var next *node
-	var journey [MaxHeight]*node
-
-	prev := sl.head
-	for level := sl.height - 1; level >= 0; level-- {
-		for next = prev.tower[level]; next != nil; next = prev.tower[level] {
-			if bytes.Compare(key, next.key) <= 0 {
-				break
-			}
-			prev = next
-		}
-		journey[level] = prev
+// newNode allocates a node of the given height and copies "key" and "val" into the arena.
+func (sl *SkipList) newNode(height int, key, val []byte) (uint32, error) {
+	unusedSize := (MaxHeight - height) * linkSize
+	nodeSize := maxNodeSize - unusedSize
+	offset, err := sl.arena.Alloc(nodeSize + len(key) + len(val))
+	if err != nil {
+		return 0, err
 	}
+	nd := sl.node(offset)
+	nd.keyOffset = offset + uint32(nodeSize)
+	nd.keySize = uint32(len(key))
+	nd.valSize = uint32(len(val))
+	copy(sl.arena.bytes(nd.keyOffset, nd.keySize), key)
+	copy(sl.arena.bytes(nd.keyOffset+nd.keySize, nd.valSize), val)
+	return offset, nil
+}
 
-	if next != nil && bytes.Equal(key, next.key) {
-		return next, journey
-	}
-	return nil, journey
+func (nd *node) next(level int) uint32 {
+	return atomic.LoadUint32(&nd.tower[level])
 }
 
-func (sl *SkipList) Find(key []byte) ([]byte, error) {
-	found, _ := sl.search(key)
+func (nd *node) casNext(level int, old, new uint32) bool {
+	return atomic.CompareAndSwapUint32(&nd.tower[level], old, new)
+}
 
-	if found == nil {
-		return nil, ErrKeyNotFound
+func (sl *SkipList) node(offset uint32) *node {
+	if offset == 0 {
+		return nil
 	}
+	return (*node)(sl.arena.pointer(offset))
+}
 
-	return found.val, nil
+func (sl *SkipList) key(nd *node) []byte {
+	return sl.arena.bytes(nd.keyOffset, nd.keySize)
 }
 
-func (sl *SkipList) Insert(key []byte, val []byte) {
-	found, journey := sl.search(key)
+func (sl *SkipList) val(nd *node) []byte {
+	return sl.arena.bytes(nd.keyOffset+nd.keySize, nd.valSize)
+}
 
-	if found != nil {
-		// update value of existing key
-		found.val = val
-		return
+// findSplice locates the nodes between which "key" belongs at every level below "height". It reports whether a node
+// holding "key" exists.
+func (sl *SkipList) findSplice(key []byte, height int, prev, next *[MaxHeight]uint32) (found bool) {
+	start := sl.head
+	for level := height - 1; level >= 0; level-- {
+		prev[level], next[level], found = sl.findSpliceForLevel(key, level, start)
+		start = prev[level]
 	}
-	height := randomHeight()
-	nd := &node{key: key, val: val}
-
-	for level := 0; level < height; level++ {
-		prev := journey[level]
+	return found
+}
 
-		if prev == nil {
-			// prev is nil if we are extending the height of the tree,
-			// because that level did not exist while the journey was being recorded
-			prev = sl.head
+// findSpliceForLevel walks "level" from node "start", which must sort before "key", and returns the last node sorting
+// before "key" together with its successor. The successor holds "key" if found is true.
+func (sl *SkipList) findSpliceForLevel(key []byte, level int, start uint32) (prev, next uint32, found bool) {
+	prev = start
+	for {
+		next = sl.node(prev).next(level)
+		if next == 0 {
+			return prev, 0, false
+		}
+		cmp := sl.compare(key, sl.key(sl.node(next)))
+		if cmp <= 0 {
+			return prev, next, cmp == 0
 		}
-		nd.tower[level] = prev.tower[level]
-		prev.tower[level] = nd
+		prev = next
 	}
+}
 
-	if height > sl.height {
-		sl.height = height
-	}
+// search returns the predecessor of the first node holding a key greater than or equal to "key" together with that
+// node, which holds "key" if found is true.
+func (sl *SkipList) search(key []byte) (prev, next uint32, found bool) {
+	var prevs, nexts [MaxHeight]uint32
+	found = sl.findSplice(key, sl.Height(), &prevs, &nexts)
+	return prevs[0], nexts[0], found
 }
 
-func (sl *SkipList) Delete(key []byte) bool {
-	found, journey := sl.search(key)
+func (sl *SkipList) Find(key []byte) ([]byte, error) {
+	_, next, found := sl.search(key)
+
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+
+	return sl.val(sl.node(next)), nil
+}
 
-	if found == nil {
-		return false
+// Insert copies "key" and "val" into the arena and links them into the list. ErrArenaFull is returned once the arena
+// has no room left for the node, and ErrRecordExists if "key" is already present, as the value of an existing node
+// cannot be replaced in place. Insert may be called concurrently with other calls to Insert and with readers.
+func (sl *SkipList) Insert(key []byte, val []byte) error {
+	var prev, next [MaxHeight]uint32
+	listHeight := sl.Height()
+	if sl.findSplice(key, listHeight, &prev, &next) {
+		return ErrRecordExists
+	}
+	height := randomHeight()
+	offset, err := sl.newNode(height, key, val)
+	if err != nil {
+		return err
 	}
+	nd := sl.node(offset)
 
-	for level := 0; level < sl.height; level++ {
-		if journey[level].tower[level] != found {
+	// raise the height of the list, unless a concurrent insert raised it even further
+	for h := sl.height.Load(); int32(height) > h; h = sl.height.Load() {
+		if sl.height.CompareAndSwap(h, int32(height)) {
 			break
 		}
-		journey[level].tower[level] = found.tower[level]
-		found.tower[level] = nil
 	}
-	found = nil
-	sl.shrink()
 
-	return true
-}
-
-func (sl *SkipList) shrink() {
-	for level := sl.height - 1; level >= 0; level-- {
-		if sl.head.tower[level] == nil {
-			sl.height--
+	// link the node bottom-up, so that it becomes visible to readers as soon as it is linked at level 0
+	for level := 0; level < height; level++ {
+		if level >= listHeight {
+			// the level did not exist while the splice was being recorded
+			prev[level], next[level] = sl.head, 0
+		}
+		for {
+			atomic.StoreUint32(&nd.tower[level], next[level])
+			if sl.node(prev[level]).casNext(level, next[level], offset) {
+				break
+			}
+			// a concurrent insert changed the splice, so find it again starting from the previous predecessor
+			var found bool
+			prev[level], next[level], found = sl.findSpliceForLevel(key, level, prev[level])
+			if found {
+				// only possible at level 0, since the node is already linked at every level below
+				return ErrRecordExists
+			}
 		}
 	}
+	return nil
+}
+
+// Height returns the number of levels currently in use.
+func (sl *SkipList) Height() int {
+	return int(sl.height.Load())
+}
+
+// Size returns the amount of arena space (in bytes) taken up by the list so far.
+func (sl *SkipList) Size() int {
+	return sl.arena.Size()
 }
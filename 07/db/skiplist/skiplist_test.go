@@ -0,0 +1,215 @@
+package skiplist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	numWriters    = 8
+	keysPerWriter = 2000
+)
+
+func makeKey(writer, i int) []byte {
+	// interleave the keys of all writers, so that they contend for the same splices
+	return binary.BigEndian.AppendUint32(nil, uint32(i*numWriters+writer))
+}
+
+func newTestSkipList(t *testing.T, capacity int) *SkipList {
+	t.Helper()
+	sl, err := NewSkipList(NewArena(capacity), bytes.Compare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sl
+}
+
+// checkOrder walks the whole list and fails unless the keys are strictly ascending.
+func checkOrder(t *testing.T, sl *SkipList) int {
+	var prev []byte
+	n := 0
+	for i := sl.Iterator(); i.HasNext(); n++ {
+		key, _ := i.Next()
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			t.Errorf("keys out of order: %x followed by %x", prev, key)
+			return n
+		}
+		prev = key
+	}
+	return n
+}
+
+func TestConcurrentInsert(t *testing.T) {
+	sl := newTestSkipList(t, 8<<20)
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				key := makeKey(w, i)
+				if err := sl.Insert(key, key); err != nil {
+					t.Errorf("insert %x: %v", key, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n := checkOrder(t, sl); n != numWriters*keysPerWriter {
+		t.Fatalf("iterated over %d keys, want %d", n, numWriters*keysPerWriter)
+	}
+	for w := 0; w < numWriters; w++ {
+		for i := 0; i < keysPerWriter; i++ {
+			key := makeKey(w, i)
+			val, err := sl.Find(key)
+			if err != nil || !bytes.Equal(val, key) {
+				t.Fatalf("find %x: got %x, %v", key, val, err)
+			}
+		}
+	}
+}
+
+func TestConcurrentInsertSameKeys(t *testing.T) {
+	sl := newTestSkipList(t, 8<<20)
+
+	var inserted, rejected atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				err := sl.Insert(makeKey(0, i), nil)
+				switch {
+				case err == nil:
+					inserted.Add(1)
+				case errors.Is(err, ErrRecordExists):
+					rejected.Add(1)
+				default:
+					t.Errorf("insert: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if inserted.Load() != keysPerWriter {
+		t.Fatalf("%d inserts succeeded, want %d", inserted.Load(), keysPerWriter)
+	}
+	if n := checkOrder(t, sl); n != keysPerWriter {
+		t.Fatalf("iterated over %d keys, want %d", n, keysPerWriter)
+	}
+}
+
+func TestReadersDuringInserts(t *testing.T) {
+	sl := newTestSkipList(t, 8<<20)
+
+	// progress[w] is the number of keys writer w has finished inserting
+	var progress [numWriters]atomic.Int64
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+
+	for w := 0; w < numWriters; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				key := makeKey(w, i)
+				if err := sl.Insert(key, key); err != nil {
+					t.Errorf("insert %x: %v", key, err)
+					return
+				}
+				progress[w].Store(int64(i + 1))
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// every key inserted before the lookup started must be found
+				w := r % numWriters
+				if n := progress[w].Load(); n > 0 {
+					key := makeKey(w, int(n-1))
+					if _, err := sl.Find(key); err != nil {
+						t.Errorf("find %x: %v", key, err)
+						return
+					}
+				}
+				// an iterator must see ascending keys, whatever it observes of the concurrent inserts
+				i := sl.Iterator()
+				i.Seek(makeKey(0, keysPerWriter/2))
+				var prev []byte
+				for i.HasNext() {
+					key, val := i.Next()
+					if !bytes.Equal(key, val) {
+						t.Errorf("key %x holds value %x", key, val)
+						return
+					}
+					if prev != nil && bytes.Compare(prev, key) >= 0 {
+						t.Errorf("keys out of order: %x followed by %x", prev, key)
+						return
+					}
+					prev = key
+				}
+			}
+		}(r)
+	}
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	if n := checkOrder(t, sl); n != numWriters*keysPerWriter {
+		t.Fatalf("iterated over %d keys, want %d", n, numWriters*keysPerWriter)
+	}
+}
+
+func TestArenaFull(t *testing.T) {
+	const capacity = 64 << 10
+	sl := newTestSkipList(t, capacity)
+
+	var wg sync.WaitGroup
+	var inserted atomic.Int64
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				err := sl.Insert(makeKey(w, i), make([]byte, 32))
+				if errors.Is(err, ErrArenaFull) {
+					return
+				}
+				if err != nil {
+					t.Errorf("insert: %v", err)
+					return
+				}
+				inserted.Add(1)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if sl.Size() > capacity {
+		t.Fatalf("arena holds %d bytes, exceeding its capacity of %d", sl.Size(), capacity)
+	}
+	if capacity-sl.Size() >= MaxNodeSize(4, 32) {
+		t.Fatalf("arena reported full with %d of %d bytes free", capacity-sl.Size(), capacity)
+	}
+	if n := checkOrder(t, sl); n != int(inserted.Load()) {
+		t.Fatalf("iterated over %d keys, want %d", n, inserted.Load())
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"container/list"
+	"errors"
+)
+
+var ErrSnapshotReleased = errors.New("snapshot released")
+
+// Snapshot provides a consistent, read-only view of the database as of the moment it was taken.
+// Writes applied after the snapshot was taken are invisible through it.
+type Snapshot struct {
+	db     *DB
+	seqNum uint64
+	elem   *list.Element
+}
+
+// snapshotList tracks the open snapshots from the oldest to the newest one.
+type snapshotList struct {
+	list.List
+}
+
+// NewSnapshot captures the current state of the database. Versions of keys visible to the snapshot are preserved by
+// flushes and compactions until the snapshot is released with Close.
+func (d *DB) NewSnapshot() (*Snapshot, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	s := &Snapshot{db: d, seqNum: d.seqNum}
+	s.elem = d.snapshots.PushBack(s)
+	return s, nil
+}
+
+// smallestSnapshot returns the sequence number of the oldest open snapshot. Flushes and compactions may discard any
+// version of a key that is shadowed by a more recent version whose sequence number does not exceed it. It is called
+// with d.mu held.
+func (d *DB) smallestSnapshot() uint64 {
+	if front := d.snapshots.Front(); front != nil {
+		return front.Value.(*Snapshot).seqNum
+	}
+	return d.seqNum
+}
+
+// Get returns the value that "key" had when the snapshot was taken.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	return s.db.get(key, s)
+}
+
+// NewIterator returns an iterator over the key range [lower, upper) as it was when the snapshot was taken.
+func (s *Snapshot) NewIterator(lower, upper []byte) (*Iterator, error) {
+	return s.db.newIterator(lower, upper, s)
+}
+
+// SeqNum returns the sequence number of the most recent write visible to the snapshot.
+func (s *Snapshot) SeqNum() uint64 {
+	return s.seqNum
+}
+
+// Close releases the snapshot, allowing the versions only it could observe to be discarded.
+func (s *Snapshot) Close() error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.elem == nil {
+		return ErrSnapshotReleased
+	}
+	s.db.snapshots.Remove(s.elem)
+	s.elem = nil
+	return nil
+}
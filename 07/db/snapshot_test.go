@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+// storedVersions counts the versions of "key" held by the tables of the current version, tombstones included.
+func storedVersions(t *testing.T, d *DB, key string) int {
+	t.Helper()
+	d.mu.Lock()
+	tables := d.current.tables()
+	d.mu.Unlock()
+	var n int
+	for _, meta := range tables {
+		iter, err := d.newTableIterator(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ok := iter.First(); ok; ok = iter.Next() {
+			if string(encoder.UserKey(iter.Key())) == key {
+				n++
+			}
+		}
+		if err = errors.Join(iter.Error(), iter.Close()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return n
+}
+
+func TestSnapshotSurvivesFlushAndCompaction(t *testing.T) {
+	discardLogs(t)
+	d, err := Open("db", &Options{FS: vfs.NewMem(), L0CompactionTrigger: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	const numKeys = 10
+	key := func(k int) []byte { return []byte(fmt.Sprintf("k%02d", k)) }
+	for k := 0; k < numKeys; k++ {
+		if err = d.Set(key(k), []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := d.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < numKeys; k++ {
+		if err = d.Set(key(k), []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.Delete(key(5)); err != nil {
+		t.Fatal(err)
+	}
+	// two flushes reach the compaction trigger, which moves every version into level 1
+	flush(t, d)
+	if err = d.Set(key(0), []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	flush(t, d)
+	if levels := levelFileNums(d); len(levels[0]) != 0 || len(levels[1]) == 0 {
+		t.Fatalf("got levels %v, want every table compacted into level 1", levels)
+	}
+
+	var want []string
+	for k := 0; k < numKeys; k++ {
+		if val, err := s.Get(key(k)); err != nil || string(val) != "v1" {
+			t.Fatalf("snapshot get %s: got %q (%v), want %q", key(k), val, err, "v1")
+		}
+		want = append(want, fmt.Sprintf("%s=v1", key(k)))
+	}
+	iter, err := s.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for ok := iter.First(); ok; ok = iter.Next() {
+		got = append(got, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+	}
+	if err = iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("snapshot scan: got %v, want %v", got, want)
+	}
+	if _, err = d.Get(key(5)); err == nil {
+		t.Fatalf("get %s: found a deleted key", key(5))
+	}
+	if n := storedVersions(t, d, "k01"); n != 2 {
+		t.Fatalf("got %d versions of k01 while the snapshot is open, want 2", n)
+	}
+
+	// once the snapshot is released, the next compaction drops the versions only it could see
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get(key(0)); !errors.Is(err, ErrSnapshotReleased) {
+		t.Fatalf("get from released snapshot: got %v, want ErrSnapshotReleased", err)
+	}
+	for _, k := range []int{0, 9} {
+		if err = d.Set(key(k), []byte("v4")); err != nil {
+			t.Fatal(err)
+		}
+		flush(t, d)
+	}
+	if levels := levelFileNums(d); len(levels[0]) != 0 {
+		t.Fatalf("got levels %v, want every table compacted into level 1", levels)
+	}
+	if n := storedVersions(t, d, "k01"); n != 1 {
+		t.Fatalf("got %d versions of k01 after the snapshot was released, want 1", n)
+	}
+	if n := storedVersions(t, d, "k05"); n != 0 {
+		t.Fatalf("got %d versions of deleted k05 after the snapshot was released, want 0", n)
+	}
+}
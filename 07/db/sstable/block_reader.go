@@ -1,9 +1,6 @@
 package sstable
 
-import (
-	"bytes"
-	"encoding/binary"
-)
+import "encoding/binary"
 
 type searchCondition int
 
@@ -50,13 +47,13 @@ func (b *blockReader) fetchDataFor(pos int) (kvOffset int, key, val []byte) {
 	return
 }
 
-func (b *blockReader) search(searchKey []byte, condition searchCondition) int {
+func (b *blockReader) search(searchKey []byte, condition searchCondition, compareKeys func(a, b []byte) int) int {
 	low, high := 0, b.numOffsets
 	var mid int
 	for low < high {
 		mid = (low + high) / 2
 		key := b.readKeyAt(mid)
-		cmp := bytes.Compare(searchKey, key)
+		cmp := compareKeys(searchKey, key)
 		if cmp >= int(condition) {
 			low = mid + 1
 		} else {
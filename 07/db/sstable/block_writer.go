@@ -3,16 +3,13 @@ package sstable
 import (
 	"bytes"
 	"encoding/binary"
-	"math"
+	"slices"
 )
 
 const (
-	maxBlockSize      = 4096
 	offsetSizeInBytes = 4
 )
 
-var blockFlushThreshold = int(math.Floor(maxBlockSize * 0.9))
-
 type blockWriter struct {
 	buf *bytes.Buffer
 
@@ -25,9 +22,9 @@ type blockWriter struct {
 	prefixKey  []byte // prefixKey of the current data chunk
 }
 
-func newBlockWriter(chunkSize int) *blockWriter {
+func newBlockWriter(chunkSize, blockSize int) *blockWriter {
 	bw := &blockWriter{}
-	bw.buf = bytes.NewBuffer(make([]byte, 0, maxBlockSize))
+	bw.buf = bytes.NewBuffer(make([]byte, 0, blockSize))
 	bw.chunkSize = chunkSize
 	return bw
 }
@@ -64,7 +61,7 @@ func (b *blockWriter) add(key, val []byte) (int, error) {
 func (b *blockWriter) calculateSharedLength(key []byte) int {
 	sharedLen := 0
 	if b.prefixKey == nil {
-		b.prefixKey = key
+		b.prefixKey = slices.Clone(key)
 		return sharedLen
 	}
 
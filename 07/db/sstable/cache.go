@@ -0,0 +1,73 @@
+package sstable
+
+import (
+	"container/list"
+	"sync"
+)
+
+// BlockCache is a size-bounded LRU cache of decompressed data blocks, shared by all readers. It is safe for
+// concurrent use.
+type BlockCache struct {
+	mu       sync.Mutex
+	capacity int // maximum total size of the cached blocks (in bytes)
+	size     int
+	lru      list.List // most recently used blocks at the front
+	blocks   map[blockCacheKey]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+type blockCacheKey struct {
+	fileNum int
+	offset  uint32
+}
+
+type cachedBlock struct {
+	key blockCacheKey
+	buf []byte
+}
+
+func NewBlockCache(capacity int) *BlockCache {
+	return &BlockCache{
+		capacity: capacity,
+		blocks:   make(map[blockCacheKey]*list.Element),
+	}
+}
+
+func (c *BlockCache) get(fileNum int, offset uint32) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.blocks[blockCacheKey{fileNum, offset}]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedBlock).buf, true
+}
+
+// add caches "buf", evicting the least recently used blocks until the cache is within its capacity again. Blocks that
+// exceed the capacity on their own are not cached at all.
+func (c *BlockCache) add(fileNum int, offset uint32, buf []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := blockCacheKey{fileNum, offset}
+	if _, ok := c.blocks[key]; ok || len(buf) > c.capacity {
+		return
+	}
+	c.blocks[key] = c.lru.PushFront(&cachedBlock{key: key, buf: buf})
+	c.size += len(buf)
+	for c.size > c.capacity {
+		b := c.lru.Remove(c.lru.Back()).(*cachedBlock)
+		delete(c.blocks, b.key)
+		c.size -= len(b.buf)
+	}
+}
+
+// Stats returns the number of lookups served from the cache and the number of lookups that missed it.
+func (c *BlockCache) Stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
@@ -0,0 +1,80 @@
+package sstable
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+func TestBlockCacheEviction(t *testing.T) {
+	c := NewBlockCache(30)
+	block := func(b byte) []byte { return bytes.Repeat([]byte{b}, 10) }
+	c.add(1, 0, block('a'))
+	c.add(1, 100, block('b'))
+	c.add(2, 0, block('c'))
+	// touching the oldest block makes the second one the least recently used
+	if buf, ok := c.get(1, 0); !ok || !bytes.Equal(buf, block('a')) {
+		t.Fatalf("get(1, 0): got %q, %v", buf, ok)
+	}
+	c.add(2, 100, block('d'))
+	c.add(3, 0, make([]byte, 31)) // larger than the cache
+
+	tests := []struct {
+		fileNum int
+		offset  uint32
+		cached  bool
+	}{
+		{1, 0, true},
+		{1, 100, false},
+		{2, 0, true},
+		{2, 100, true},
+		{3, 0, false},
+	}
+	for _, tt := range tests {
+		if _, ok := c.get(tt.fileNum, tt.offset); ok != tt.cached {
+			t.Errorf("get(%d, %d): got cached = %v, want %v", tt.fileNum, tt.offset, ok, tt.cached)
+		}
+	}
+	if c.size != 30 {
+		t.Errorf("got size %d, want 30", c.size)
+	}
+	if hits, misses := c.Stats(); hits != 4 || misses != 2 {
+		t.Errorf("got %d hits and %d misses, want 4 and 2", hits, misses)
+	}
+}
+
+func TestBlockCacheReader(t *testing.T) {
+	fs := vfs.NewMem()
+	buf := writeTestTable(t, fs)
+	cache := NewBlockCache(1 << 20)
+	f, err := fs.Create("cached.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f, ReaderOptions{Cache: cache, FileNum: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the first lookup loads the data block, the second one finds it in the cache
+	for i, want := range []struct{ hits, misses uint64 }{{0, 1}, {1, 1}} {
+		if _, err = r.Get(testTableKey(0), 1); err != nil {
+			t.Fatal(err)
+		}
+		if hits, misses := cache.Stats(); hits != want.hits || misses != want.misses {
+			t.Fatalf("lookup %d: got %d hits and %d misses, want %d and %d", i, hits, misses, want.hits, want.misses)
+		}
+	}
+	// a key in another data block misses again
+	if _, err = r.Get(testTableKey(testTableKeys-1), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, misses := cache.Stats(); misses != 2 {
+		t.Fatalf("got %d misses, want 2", misses)
+	}
+}
@@ -0,0 +1,174 @@
+package sstable
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Compression identifies the codec a block was compressed with. It is stored in the trailer that follows every block,
+// so a single *.sst file may hold blocks compressed in different ways.
+type Compression byte
+
+const (
+	DefaultCompression Compression = iota // selects SnappyCompression, never written to disk
+	NoCompression
+	SnappyCompression
+	FlateCompression
+)
+
+// DefaultMinCompressionSavings stores a block uncompressed unless compression shrinks it by at least 12.5%.
+const DefaultMinCompressionSavings = 0.125
+
+var ErrUnknownCompression = errors.New("unknown compression type")
+
+// Codec compresses and decompresses blocks. Implementations must be safe for concurrent use.
+type Codec interface {
+	Name() string
+	// Encode appends the compressed form of "src" to "dst".
+	Encode(dst, src []byte) ([]byte, error)
+	// Decode returns the decompressed form of "src".
+	Decode(src []byte) ([]byte, error)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[Compression]Codec
+}{m: map[Compression]Codec{
+	NoCompression:     noneCodec{},
+	SnappyCompression: snappyCodec{},
+	FlateCompression:  &flateCodec{},
+}}
+
+// RegisterCodec makes "codec" available for blocks of compression type "c", replacing any codec registered before.
+// Readers must have the same codecs registered as the writers of the *.sst files they open.
+func RegisterCodec(c Compression, codec Codec) {
+	if c == DefaultCompression {
+		panic("sstable: cannot register a codec for DefaultCompression")
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[c] = codec
+}
+
+// LookupCodec returns the codec registered for compression type "c".
+func LookupCodec(c Compression) (Codec, error) {
+	if c == DefaultCompression {
+		c = SnappyCompression
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, ok := codecs.m[c]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCompression, c)
+	}
+	return codec, nil
+}
+
+func (c Compression) String() string {
+	codec, err := LookupCodec(c)
+	if err != nil {
+		return fmt.Sprintf("compression(%d)", byte(c))
+	}
+	return codec.Name()
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return "none" }
+
+func (noneCodec) Encode(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+func (noneCodec) Decode(src []byte) ([]byte, error) {
+	return src, nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string { return "snappy" }
+
+func (snappyCodec) Encode(dst, src []byte) ([]byte, error) {
+	n := len(dst)
+	dst = append(dst, make([]byte, snappy.MaxEncodedLen(len(src)))...)
+	encoded := snappy.Encode(dst[n:], src)
+	return dst[:n+len(encoded)], nil
+}
+
+func (snappyCodec) Decode(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// flateCodec applies DEFLATE (RFC 1951), trading more CPU time than snappy for smaller blocks.
+type flateCodec struct {
+	writers sync.Pool
+}
+
+func (*flateCodec) Name() string { return "flate" }
+
+func (c *flateCodec) Encode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	fw, _ := c.writers.Get().(*flate.Writer)
+	if fw == nil {
+		var err error
+		if fw, err = flate.NewWriter(buf, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	} else {
+		fw.Reset(buf)
+	}
+	defer c.writers.Put(fw)
+	if _, err := fw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (*flateCodec) Decode(src []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(src))
+	defer fr.Close()
+	return io.ReadAll(fr)
+}
+
+// compressBlock appends "block" to "dst", compressed with "c" if that saves at least the fraction "minSavings" of its
+// size, and returns the compression type actually applied.
+func compressBlock(dst, block []byte, c Compression, minSavings float64) ([]byte, Compression, error) {
+	if c == DefaultCompression {
+		c = SnappyCompression
+	}
+	if c != NoCompression {
+		codec, err := LookupCodec(c)
+		if err != nil {
+			return nil, 0, err
+		}
+		compressed, err := codec.Encode(dst, block)
+		if err != nil {
+			return nil, 0, err
+		}
+		if float64(len(compressed)-len(dst)) <= float64(len(block))*(1-minSavings) {
+			return compressed, c, nil
+		}
+	}
+	return append(dst, block...), NoCompression, nil
+}
+
+// decompressBlock returns the decompressed form of block "contents" compressed with "c".
+func decompressBlock(contents []byte, c Compression) ([]byte, error) {
+	if c == DefaultCompression {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCompression, c)
+	}
+	codec, err := LookupCodec(c)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(contents)
+}
@@ -0,0 +1,69 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressBlock(t *testing.T) {
+	compressible := bytes.Repeat([]byte("key000042 value of moderate length "), 100)
+	incompressible := make([]byte, len(compressible))
+	rand.New(rand.NewSource(1)).Read(incompressible)
+
+	tests := []struct {
+		name       string
+		block      []byte
+		c          Compression
+		minSavings float64
+		want       Compression
+	}{
+		{"none", compressible, NoCompression, DefaultMinCompressionSavings, NoCompression},
+		{"default", compressible, DefaultCompression, DefaultMinCompressionSavings, SnappyCompression},
+		{"snappy", compressible, SnappyCompression, DefaultMinCompressionSavings, SnappyCompression},
+		{"flate", compressible, FlateCompression, DefaultMinCompressionSavings, FlateCompression},
+		{"snappy incompressible", incompressible, SnappyCompression, DefaultMinCompressionSavings, NoCompression},
+		{"flate incompressible", incompressible, FlateCompression, DefaultMinCompressionSavings, NoCompression},
+		{"savings below minimum", compressible, SnappyCompression, 0.999, NoCompression},
+		{"empty block", nil, FlateCompression, DefaultMinCompressionSavings, NoCompression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the compressed block is appended to whatever the buffer already holds
+			prefix := []byte("prefix")
+			buf, c, err := compressBlock(bytes.Clone(prefix), tt.block, tt.c, tt.minSavings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != tt.want {
+				t.Fatalf("got compression %s, want %s", c, tt.want)
+			}
+			if !bytes.HasPrefix(buf, prefix) {
+				t.Fatalf("prefix overwritten: %q", buf[:len(prefix)])
+			}
+			contents := buf[len(prefix):]
+			if c != NoCompression && float64(len(contents)) > float64(len(tt.block))*(1-tt.minSavings) {
+				t.Fatalf("compressed %d bytes to %d, which saves less than %.3f", len(tt.block), len(contents), tt.minSavings)
+			}
+			got, err := decompressBlock(contents, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.block) {
+				t.Fatalf("round trip changed the block")
+			}
+		})
+	}
+}
+
+func TestUnknownCompression(t *testing.T) {
+	for _, c := range []Compression{DefaultCompression, Compression(200)} {
+		if _, err := decompressBlock([]byte("data"), c); !errors.Is(err, ErrUnknownCompression) {
+			t.Errorf("%s: got %v, want ErrUnknownCompression", c, err)
+		}
+	}
+	if _, _, err := compressBlock(nil, []byte("data"), Compression(200), 0); !errors.Is(err, ErrUnknownCompression) {
+		t.Errorf("compressing with an unregistered codec: got %v, want ErrUnknownCompression", err)
+	}
+}
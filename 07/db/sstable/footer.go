@@ -0,0 +1,119 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// tableFooterSize is the length of the footer that concludes every *.sst file: the handles of the filter block, the
+// index block, and the properties block, each made up of an offset (4 bytes) followed by a length (4 bytes), then the
+// format version (4 bytes) and the magic number (8 bytes).
+const tableFooterSize = 36
+
+const (
+	tableMagic    uint64 = 0xdb07_55ab_1e5f_11e5
+	formatVersion uint32 = 2
+)
+
+// BlockTrailerSize is the length of the trailer that follows every block: the compression type (1 byte) and a CRC32C
+// of the block contents and the compression type (4 bytes).
+const BlockTrailerSize = 5
+
+var (
+	ErrCorruption        = errors.New("sstable corrupted")
+	ErrUnsupportedFormat = errors.New("unsupported sstable format version")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptionError describes damage detected in an *.sst file, e.g., a block whose checksum doesn't match or a footer
+// that doesn't belong to an *.sst file. It matches ErrCorruption when used with errors.Is.
+type CorruptionError struct {
+	FileNum int
+	Offset  int64 // offset of the damaged block or footer within the *.sst file
+	Reason  string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("sstable %06d corrupted at offset %d: %s", e.FileNum, e.Offset, e.Reason)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruption
+}
+
+// blockHandle locates a block within the *.sst file. The length excludes the block trailer.
+type blockHandle struct {
+	offset uint32
+	length uint32
+}
+
+type tableFooter struct {
+	filter     blockHandle
+	index      blockHandle
+	properties blockHandle
+	version    uint32
+	offset     int64 // offset of the footer within the *.sst file, which is where the last block must end
+}
+
+func (f *tableFooter) encode() []byte {
+	buf := make([]byte, tableFooterSize)
+	binary.LittleEndian.PutUint32(buf[0:], f.filter.offset)
+	binary.LittleEndian.PutUint32(buf[4:], f.filter.length)
+	binary.LittleEndian.PutUint32(buf[8:], f.index.offset)
+	binary.LittleEndian.PutUint32(buf[12:], f.index.length)
+	binary.LittleEndian.PutUint32(buf[16:], f.properties.offset)
+	binary.LittleEndian.PutUint32(buf[20:], f.properties.length)
+	binary.LittleEndian.PutUint32(buf[24:], f.version)
+	binary.LittleEndian.PutUint64(buf[28:], tableMagic)
+	return buf
+}
+
+// decodeFooter parses "buf", which holds the final bytes of a file of "fileSize" bytes, and ensures that the blocks the
+// footer references lie within the file. A non-empty reason is returned for a footer that is damaged or doesn't belong
+// to an *.sst file.
+func decodeFooter(buf []byte, fileSize int64) (f *tableFooter, reason string, err error) {
+	if len(buf) < tableFooterSize {
+		return nil, "file too short to hold a footer", nil
+	}
+	buf = buf[len(buf)-tableFooterSize:]
+	if binary.LittleEndian.Uint64(buf[28:]) != tableMagic {
+		return nil, "bad magic number", nil
+	}
+	f = &tableFooter{version: binary.LittleEndian.Uint32(buf[24:])}
+	if f.version != formatVersion {
+		return nil, "", fmt.Errorf("%w: %d", ErrUnsupportedFormat, f.version)
+	}
+	f.filter = blockHandle{binary.LittleEndian.Uint32(buf[0:]), binary.LittleEndian.Uint32(buf[4:])}
+	f.index = blockHandle{binary.LittleEndian.Uint32(buf[8:]), binary.LittleEndian.Uint32(buf[12:])}
+	f.properties = blockHandle{binary.LittleEndian.Uint32(buf[16:]), binary.LittleEndian.Uint32(buf[20:])}
+	f.offset = fileSize - int64(len(buf))
+	for _, h := range []blockHandle{f.filter, f.index, f.properties} {
+		if int64(h.offset)+int64(h.length)+BlockTrailerSize > f.offset {
+			return nil, "block handle out of bounds", nil
+		}
+	}
+	return f, "", nil
+}
+
+// appendBlockTrailer appends the trailer of block "contents" compressed with "c" to "dst".
+func appendBlockTrailer(dst, contents []byte, c Compression) []byte {
+	checksum := crc32.Update(crc32.Checksum(contents, crcTable), crcTable, []byte{byte(c)})
+	dst = append(dst, byte(c))
+	return binary.LittleEndian.AppendUint32(dst, checksum)
+}
+
+// verifyBlock checks the trailer at the end of "buf" and returns the block contents and their compression type.
+func verifyBlock(buf []byte) (contents []byte, c Compression, ok bool) {
+	if len(buf) < BlockTrailerSize {
+		return nil, 0, false
+	}
+	contents, trailer := buf[:len(buf)-BlockTrailerSize], buf[len(buf)-BlockTrailerSize:]
+	checksum := crc32.Update(crc32.Checksum(contents, crcTable), crcTable, trailer[:1])
+	if checksum != binary.LittleEndian.Uint32(trailer[1:]) {
+		return nil, 0, false
+	}
+	return contents, Compression(trailer[0]), true
+}
@@ -0,0 +1,137 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+const testTableKeys = 500
+
+func testTableKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%04d", i))
+}
+
+// writeTestTable returns the contents of an *.sst file spanning several data blocks.
+func writeTestTable(t *testing.T, fs vfs.FS) []byte {
+	f, err := fs.Create("test.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(f, WriterOptions{MaxBlockSize: 1 << 10})
+	e := encoder.NewEncoder()
+	for i := 0; i < testTableKeys; i++ {
+		if err = w.Add(e.EncodeKey(testTableKey(i), 1), e.Encode(encoder.OpKindSet, []byte("value"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := vfs.ReadFile(fs, "test.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func openTestTable(t *testing.T, fs vfs.FS, buf []byte) (*Reader, error) {
+	f, err := fs.Create("damaged.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	return NewReader(f, ReaderOptions{})
+}
+
+func TestCorruption(t *testing.T) {
+	fs := vfs.NewMem()
+	clean := writeTestTable(t, fs)
+	r, err := openTestTable(t, fs, clean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, err := r.Layout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if len(layout.Data) < 3 {
+		t.Fatalf("got %d data blocks, want at least 3", len(layout.Data))
+	}
+	footer := len(clean) - tableFooterSize
+	data := layout.Data[1].Handle
+
+	flip := func(off int) func([]byte) []byte {
+		return func(buf []byte) []byte {
+			buf[off] ^= 0x01
+			return buf
+		}
+	}
+	tests := []struct {
+		name   string
+		damage func([]byte) []byte
+		// openErr is the error expected from NewReader, or nil if the damage is only found when reading the data block
+		openErr error
+	}{
+		{"bad magic number", flip(len(clean) - 1), ErrCorruption},
+		{"unsupported version", flip(footer + 24), ErrUnsupportedFormat},
+		{"handle out of bounds", func(buf []byte) []byte {
+			binary.LittleEndian.PutUint32(buf[footer+12:], uint32(len(clean)))
+			return buf
+		}, ErrCorruption},
+		{"format version 1", func(buf []byte) []byte {
+			binary.LittleEndian.PutUint32(buf[footer+24:], 1)
+			return buf
+		}, ErrUnsupportedFormat},
+		{"truncated", func(buf []byte) []byte { return buf[:tableFooterSize-1] }, ErrCorruption},
+		{"truncated footer", func(buf []byte) []byte { return buf[:len(buf)-1] }, ErrCorruption},
+		{"filter block", flip(int(layout.Filter.Offset)), ErrCorruption},
+		{"index block", flip(int(layout.Index.Offset)), ErrCorruption},
+		{"properties block", flip(int(layout.Properties.Offset)), ErrCorruption},
+		{"data block", flip(int(data.Offset + data.Length/2)), nil},
+		{"data block compression type", flip(int(data.Offset + data.Length)), nil},
+		{"data block checksum", flip(int(data.Offset + data.Length + BlockTrailerSize - 1)), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := openTestTable(t, fs, tt.damage(append([]byte(nil), clean...)))
+			if tt.openErr != nil {
+				if !errors.Is(err, tt.openErr) {
+					t.Fatalf("open: got %v, want %v", err, tt.openErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer r.Close()
+			if _, err = r.ReadDataBlock(data); !errors.Is(err, ErrCorruption) {
+				t.Fatalf("ReadDataBlock: got %v, want ErrCorruption", err)
+			}
+			// lookups of the keys held by the damaged block fail, while the other keys remain readable
+			corrupted := 0
+			for i := 0; i < testTableKeys; i++ {
+				_, err := r.Get(testTableKey(i), encoder.MaxSeqNum)
+				switch {
+				case errors.Is(err, ErrCorruption):
+					corrupted++
+				case err != nil:
+					t.Fatalf("get %s: %v", testTableKey(i), err)
+				}
+			}
+			if corrupted == 0 || corrupted == testTableKeys {
+				t.Fatalf("%d of %d lookups failed, want those of a single data block", corrupted, testTableKeys)
+			}
+		})
+	}
+}
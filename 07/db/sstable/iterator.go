@@ -0,0 +1,136 @@
+package sstable
+
+import "encoding/binary"
+
+// Iterator walks the key-value pairs of an *.sst file in ascending key order.
+type Iterator struct {
+	r     *Reader
+	index *blockReader
+	pos   int // position of the current data block in the index block
+
+	data      *blockReader // decompressed contents of the current data block
+	offset    int          // offset of the next entry in the current data block
+	end       int          // offset at which the entries of the current data block end
+	prefixKey []byte       // prefixKey of the current data chunk
+
+	key   []byte
+	val   []byte
+	valid bool
+	err   error
+}
+
+// NewIterator returns an iterator over the *.sst file. The Reader must stay open for as long as the iterator is used.
+func (r *Reader) NewIterator() *Iterator {
+	return r.newIterator()
+}
+
+func (r *Reader) newIterator() *Iterator {
+	return &Iterator{r: r, index: r.index}
+}
+
+// First moves the iterator to the smallest key in the *.sst file.
+func (i *Iterator) First() bool {
+	if !i.loadDataBlock(0) {
+		return false
+	}
+	return i.Next()
+}
+
+// SeekGE moves the iterator to the first key greater than or equal to "key".
+func (i *Iterator) SeekGE(key []byte) bool {
+	pos := i.index.search(key, moveUpWhenKeyGT, i.r.compareKeys)
+	if !i.loadDataBlock(pos) {
+		return false
+	}
+	// Skip the data chunks whose keys are all smaller than "key".
+	if chunk := i.data.search(key, moveUpWhenKeyGTE, i.r.compareKeys) - 1; chunk > 0 {
+		i.offset = i.data.readOffsetAt(chunk)
+	}
+	for i.Next() {
+		if i.r.compareKeys(i.key, key) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Next advances the iterator to the subsequent key, loading the next data block when the current one is exhausted.
+func (i *Iterator) Next() bool {
+	for i.offset >= i.end {
+		if !i.loadDataBlock(i.pos + 1) {
+			return false
+		}
+	}
+	var keyLen, valLen uint64
+	buf := i.data.buf
+	sharedLen, n := binary.Uvarint(buf[i.offset:])
+	i.offset += n
+	keyLen, n = binary.Uvarint(buf[i.offset:])
+	i.offset += n
+	valLen, n = binary.Uvarint(buf[i.offset:])
+	i.offset += n
+
+	i.key = append(i.key[:0], i.prefixKey[:sharedLen]...)
+	i.key = append(i.key, buf[i.offset:i.offset+int(keyLen)]...)
+	i.offset += int(keyLen)
+	i.val = buf[i.offset : i.offset+int(valLen)]
+	i.offset += int(valLen)
+	if sharedLen == 0 {
+		i.prefixKey = append(i.prefixKey[:0], i.key...)
+	}
+	i.valid = true
+	return true
+}
+
+func (i *Iterator) loadDataBlock(pos int) bool {
+	i.key, i.val, i.valid = i.key[:0], nil, false
+	i.pos = pos
+	if pos < 0 || pos >= i.index.numOffsets {
+		i.data, i.offset, i.end = nil, 0, 0
+		return false
+	}
+	indexEntry := i.index.readValAt(pos)
+	val := i.r.encoder.Parse(indexEntry).Value()
+	offset := binary.LittleEndian.Uint32(val[:4])
+	length := binary.LittleEndian.Uint32(val[4:])
+	h := blockHandle{offset: offset, length: length}
+	buf, err := i.r.readDataBlock(h)
+	if err != nil {
+		i.err = err
+		return false
+	}
+	if i.data, err = i.r.prepareBlockReader(buf, h); err != nil {
+		i.err = err
+		return false
+	}
+	i.offset = 0
+	i.end = len(buf) - (i.data.numOffsets+2)*offsetSizeInBytes
+	i.prefixKey = i.prefixKey[:0]
+	return true
+}
+
+// Valid reports whether the iterator is positioned at a key-value pair.
+func (i *Iterator) Valid() bool {
+	return i.valid
+}
+
+// Key returns the key at the current position. It remains valid only until the next call to Next.
+func (i *Iterator) Key() []byte {
+	return i.key
+}
+
+// Value returns the encoded value at the current position. It remains valid only until the next call to Next.
+func (i *Iterator) Value() []byte {
+	return i.val
+}
+
+// Error returns any I/O or decoding error encountered while iterating.
+func (i *Iterator) Error() error {
+	return i.err
+}
+
+// Close releases the iterator. The Reader it was created from remains open.
+func (i *Iterator) Close() error {
+	i.index, i.data = nil, nil
+	return nil
+}
@@ -0,0 +1,158 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+)
+
+// BlockHandle locates a block within an *.sst file. The length excludes the block trailer.
+type BlockHandle struct {
+	Offset uint32
+	Length uint32
+}
+
+func (h blockHandle) export() BlockHandle {
+	return BlockHandle{Offset: h.offset, Length: h.length}
+}
+
+// IndexEntry references a data block from the index block. Its key sorts after every key of the data block and
+// before every key of the subsequent one.
+type IndexEntry struct {
+	Key    []byte
+	Handle BlockHandle
+}
+
+// Layout describes the physical structure of an *.sst file, as recorded in its footer and index block.
+type Layout struct {
+	Version    uint32
+	Footer     int64 // offset of the footer
+	Filter     BlockHandle
+	Index      BlockHandle
+	Properties BlockHandle
+	Data       []IndexEntry
+}
+
+// BlockEntry is a key-value pair of a block. The value is kept in its encoded form.
+type BlockEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// DataBlock holds the decoded contents of a data block.
+type DataBlock struct {
+	Compression  Compression // compression type of the block as stored in the file
+	Size         int         // size of the decompressed block (in bytes)
+	ChunkOffsets []uint32    // offsets of the prefix-compressed chunks within the decompressed block
+	Entries      []BlockEntry
+}
+
+// Layout decodes the footer and the index block of the *.sst file. Unlike iterators, it validates the structure of
+// the index block entry by entry and reports any damage as a *CorruptionError.
+func (r *Reader) Layout() (*Layout, error) {
+	l := &Layout{
+		Version:    r.footer.version,
+		Footer:     r.footer.offset,
+		Filter:     r.footer.filter.export(),
+		Index:      r.footer.index.export(),
+		Properties: r.footer.properties.export(),
+	}
+	_, entries, reason := decodeBlock(r.index.buf)
+	if reason != "" {
+		return nil, r.corruption(int64(r.footer.index.offset), "index block: "+reason)
+	}
+	for i, e := range entries {
+		val := e.Value
+		if len(val) != 9 || encoder.OpKind(val[0]) != encoder.OpKindSet {
+			return nil, r.corruption(int64(r.footer.index.offset), fmt.Sprintf("index block: malformed entry %d", i))
+		}
+		l.Data = append(l.Data, IndexEntry{
+			Key:    e.Key,
+			Handle: BlockHandle{binary.LittleEndian.Uint32(val[1:]), binary.LittleEndian.Uint32(val[5:])},
+		})
+	}
+	return l, nil
+}
+
+// ReadDataBlock reads, verifies, and fully decodes the data block located by "h", bypassing the block cache. Any
+// damage is reported as a *CorruptionError.
+func (r *Reader) ReadDataBlock(h BlockHandle) (*DataBlock, error) {
+	contents, c, err := r.readRawBlock(blockHandle{h.Offset, h.Length})
+	if err != nil {
+		return nil, err
+	}
+	buf, err := decompressBlock(contents, c)
+	if err != nil {
+		return nil, r.corruption(int64(h.Offset), err.Error())
+	}
+	offsets, entries, reason := decodeBlock(buf)
+	if reason != "" {
+		return nil, r.corruption(int64(h.Offset), reason)
+	}
+	return &DataBlock{Compression: c, Size: len(buf), ChunkOffsets: offsets, Entries: entries}, nil
+}
+
+// decodeBlock parses a block produced by a blockWriter, checking every length and offset against the bounds of
+// "buf". A non-empty reason is returned for a malformed block.
+func decodeBlock(buf []byte) (offsets []uint32, entries []BlockEntry, reason string) {
+	if len(buf) < footerSizeInBytes {
+		return nil, nil, "block too short"
+	}
+	footer := buf[len(buf)-footerSizeInBytes:]
+	length := int(binary.LittleEndian.Uint32(footer[:4]))
+	numOffsets := int(binary.LittleEndian.Uint32(footer[4:]))
+	if length != len(buf) || numOffsets > length/offsetSizeInBytes-2 {
+		return nil, nil, "malformed block footer"
+	}
+	end := length - (numOffsets+2)*offsetSizeInBytes
+	for i := 0; i < numOffsets; i++ {
+		offset := binary.LittleEndian.Uint32(buf[end+i*offsetSizeInBytes:])
+		if int(offset) >= end || (i == 0 && offset != 0) || (i > 0 && offset <= offsets[i-1]) {
+			return nil, nil, fmt.Sprintf("chunk offset %d out of order or out of bounds", offset)
+		}
+		offsets = append(offsets, offset)
+	}
+	if numOffsets == 0 && end > 0 {
+		return nil, nil, "entries without chunk offsets"
+	}
+
+	var prefixKey []byte
+	chunk := 0 // index of the next chunk offset
+	for offset := 0; offset < end; {
+		chunkStart := chunk < numOffsets && int(offsets[chunk]) == offset
+		if chunk < numOffsets && int(offsets[chunk]) < offset {
+			return nil, nil, fmt.Sprintf("chunk offset %d does not start an entry", offsets[chunk])
+		}
+		var fields [3]uint64 // shared key length, unshared key length, value length
+		for i := range fields {
+			v, n := binary.Uvarint(buf[offset:end])
+			if n <= 0 {
+				return nil, nil, fmt.Sprintf("malformed entry header at offset %d", offset)
+			}
+			fields[i] = v
+			offset += n
+		}
+		sharedLen, keyLen, valLen := fields[0], fields[1], fields[2]
+		if chunkStart {
+			if sharedLen != 0 {
+				return nil, nil, fmt.Sprintf("first entry of chunk at offset %d shares a prefix", offsets[chunk])
+			}
+			chunk++
+		}
+		if sharedLen > uint64(len(prefixKey)) || keyLen > uint64(end-offset) || valLen > uint64(end-offset)-keyLen {
+			return nil, nil, fmt.Sprintf("entry at offset %d out of bounds", offset)
+		}
+		key := append(prefixKey[:sharedLen:sharedLen], buf[offset:offset+int(keyLen)]...)
+		offset += int(keyLen)
+		if chunkStart {
+			prefixKey = key
+		}
+		entries = append(entries, BlockEntry{Key: key, Value: buf[offset : offset+int(valLen)]})
+		offset += int(valLen)
+	}
+	if chunk < numOffsets {
+		return nil, nil, fmt.Sprintf("chunk offset %d does not start an entry", offsets[chunk])
+	}
+	return offsets, entries, ""
+}
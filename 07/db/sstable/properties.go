@@ -0,0 +1,147 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+)
+
+// names of the entries of the properties block
+const (
+	propNumEntries    = "sstable.num.entries"
+	propNumTombstones = "sstable.num.tombstones"
+	propRawKeySize    = "sstable.raw.key.size"
+	propRawValueSize  = "sstable.raw.value.size"
+	propDataSize      = "sstable.data.size"
+	propNumDataBlocks = "sstable.num.data.blocks"
+	propSmallestKey   = "sstable.smallest.key"
+	propLargestKey    = "sstable.largest.key"
+	propCompression   = "sstable.compression"
+	propCreationTime  = "sstable.creation.time"
+	propComparerName  = "sstable.comparer"
+)
+
+// Properties hold statistics about an *.sst file, collected by the Writer and stored in the properties block.
+type Properties struct {
+	NumEntries    uint64 // number of key-value pairs, including tombstones
+	NumTombstones uint64
+	RawKeySize    uint64 // total size of the internal keys (in bytes), before any compression
+	RawValueSize  uint64 // total size of the encoded values (in bytes), before any compression
+	DataSize      uint64 // total size of the data blocks as stored in the file (in bytes), including block trailers
+	NumDataBlocks uint64
+
+	SmallestKey []byte // internal keys
+	LargestKey  []byte
+
+	Compression  string // codec the Writer was configured with, even though blocks that compress poorly are stored as is
+	CreationTime time.Time
+	ComparerName string
+}
+
+// add accounts for a key-value pair appended to the *.sst file.
+func (p *Properties) add(key, val []byte) {
+	if p.NumEntries == 0 {
+		p.SmallestKey = append(p.SmallestKey[:0], key...)
+	}
+	p.NumEntries++
+	if len(val) > 0 && encoder.OpKind(val[0]) == encoder.OpKindDelete {
+		p.NumTombstones++
+	}
+	p.RawKeySize += uint64(len(key))
+	p.RawValueSize += uint64(len(val))
+}
+
+// encode lays out the properties as the entries of a block sorted by name.
+func (p *Properties) encode() ([]byte, error) {
+	entries := map[string][]byte{
+		propNumEntries:    binary.AppendUvarint(nil, p.NumEntries),
+		propNumTombstones: binary.AppendUvarint(nil, p.NumTombstones),
+		propRawKeySize:    binary.AppendUvarint(nil, p.RawKeySize),
+		propRawValueSize:  binary.AppendUvarint(nil, p.RawValueSize),
+		propDataSize:      binary.AppendUvarint(nil, p.DataSize),
+		propNumDataBlocks: binary.AppendUvarint(nil, p.NumDataBlocks),
+		propSmallestKey:   p.SmallestKey,
+		propLargestKey:    p.LargestKey,
+		propCompression:   []byte(p.Compression),
+		propCreationTime:  binary.AppendVarint(nil, p.CreationTime.Unix()),
+		propComparerName:  []byte(p.ComparerName),
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	block := newBlockWriter(indexBlockChunkSize, 512)
+	for _, name := range names {
+		if _, err := block.add([]byte(name), entries[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := block.finish(); err != nil {
+		return nil, err
+	}
+	return block.buf.Bytes(), nil
+}
+
+// decodeProperties parses the entries of a properties block. Entries of unknown names are skipped, so that properties
+// can be added without breaking older readers.
+func decodeProperties(buf []byte) (*Properties, bool) {
+	p := &Properties{}
+	uints := map[string]*uint64{
+		propNumEntries:    &p.NumEntries,
+		propNumTombstones: &p.NumTombstones,
+		propRawKeySize:    &p.RawKeySize,
+		propRawValueSize:  &p.RawValueSize,
+		propDataSize:      &p.DataSize,
+		propNumDataBlocks: &p.NumDataBlocks,
+	}
+	_, entries, reason := decodeBlock(buf)
+	if reason != "" {
+		return nil, false
+	}
+	for _, e := range entries {
+		name, val := e.Key, e.Value
+		if dst, ok := uints[string(name)]; ok {
+			v, n := binary.Uvarint(val)
+			if n <= 0 {
+				return nil, false
+			}
+			*dst = v
+			continue
+		}
+		switch string(name) {
+		case propSmallestKey:
+			p.SmallestKey = slices.Clone(val)
+		case propLargestKey:
+			p.LargestKey = slices.Clone(val)
+		case propCompression:
+			p.Compression = string(val)
+		case propComparerName:
+			p.ComparerName = string(val)
+		case propCreationTime:
+			v, n := binary.Varint(val)
+			if n <= 0 {
+				return nil, false
+			}
+			p.CreationTime = time.Unix(v, 0)
+		}
+	}
+	return p, true
+}
+
+// readProperties loads the properties block.
+func (r *Reader) readProperties() error {
+	buf, err := r.readBlock(r.footer.properties)
+	if err != nil {
+		return err
+	}
+	props, ok := decodeProperties(buf)
+	if !ok {
+		return r.corruption(int64(r.footer.properties.offset), "malformed properties block")
+	}
+	r.props = props
+	return nil
+}
@@ -1,15 +1,14 @@
 package sstable
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
 	"io/fs"
 
+	"github.com/cloudcentricdev/golang-tutorials/07/db/bloom"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
-	"github.com/golang/snappy"
 )
 
 const (
@@ -18,14 +17,29 @@ const (
 
 var ErrKeyNotFound = errors.New("key not found")
 
+// Reader provides access to an *.sst file. The footer, filter block, and index block are loaded when the Reader is
+// created and stay in memory until it is closed. A Reader is safe for concurrent use.
 type Reader struct {
 	file     statReaderAtCloser
-	br       *bufio.Reader
 	buf      []byte
 	encoder  *encoder.Encoder
 	fileSize int64
+	footer   *tableFooter
+	filter   bloom.Filter
+	index    *blockReader
+	props    *Properties
+
+	cache       *BlockCache // optional, shared by the readers of all *.sst files
+	fileNum     int         // identifies the *.sst file in the block cache and in errors
+	cmp         comparer.Comparer
+	compareKeys func(a, b []byte) int
+}
 
-	compressionBuf []byte
+// ReaderOptions configures a Reader. Zero fields select the defaults.
+type ReaderOptions struct {
+	Cache    *BlockCache       // keeps decompressed data blocks, unless nil
+	FileNum  int               // identifies the *.sst file in the block cache and in errors
+	Comparer comparer.Comparer // must match the comparer the *.sst file was written with
 }
 
 type statReaderAtCloser interface {
@@ -34,17 +48,41 @@ type statReaderAtCloser interface {
 	io.Closer
 }
 
-func NewReader(file io.Reader) (*Reader, error) {
-	r := &Reader{}
+// NewReader prepares "file" for reading.
+func NewReader(file io.Reader, opts ReaderOptions) (*Reader, error) {
+	r := &Reader{cache: opts.Cache, fileNum: opts.FileNum, cmp: opts.Comparer}
+	if r.cmp == nil {
+		r.cmp = comparer.Default
+	}
+	r.compareKeys = encoder.KeyComparer(r.cmp.Compare)
 	r.file, _ = file.(statReaderAtCloser)
-	r.br = bufio.NewReader(file)
-	r.buf = make([]byte, 0, maxBlockSize)
+	r.buf = make([]byte, 0, tableFooterSize)
 
 	err := r.initFileSize()
 	if err != nil {
 		return nil, err
 	}
-	return r, err
+	err = r.readFooter()
+	if err != nil {
+		return nil, err
+	}
+	r.filter, err = r.readBlock(r.footer.filter)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := r.readBlock(r.footer.index)
+	if err != nil {
+		return nil, err
+	}
+	r.index, err = r.prepareBlockReader(buf, r.footer.index)
+	if err != nil {
+		return nil, err
+	}
+	err = r.readProperties()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 // Retrieve the size of the loaded *.sst file.
@@ -58,159 +96,123 @@ func (r *Reader) initFileSize() error {
 	return nil
 }
 
-func (r *Reader) Get(searchKey []byte) (*encoder.EncodedValue, error) {
-	return r.binarySearch(searchKey)
+// Size returns the size of the loaded *.sst file (in bytes).
+func (r *Reader) Size() int64 {
+	return r.fileSize
 }
 
-func (r *Reader) sequentialSearch(searchKey []byte) (*encoder.EncodedValue, error) {
-	for {
-		keyLen, err := binary.ReadUvarint(r.br)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		valLen, err := binary.ReadUvarint(r.br)
-		needed := keyLen + valLen
-		buf := r.buf[:needed]
-		_, err = io.ReadFull(r.br, buf)
-		if err != nil {
-			return nil, err
-		}
-		key := buf[:keyLen]
-		val := buf[keyLen:]
-		cmp := bytes.Compare(searchKey, key)
-
-		if cmp == 0 {
-			return r.encoder.Parse(val), nil
-		}
-
-		if cmp < 0 {
-			break
-		}
-	}
-	return nil, ErrKeyNotFound
+// Properties returns the statistics recorded in the properties block of the *.sst file. They must not be modified.
+func (r *Reader) Properties() *Properties {
+	return r.props
 }
 
-func (r *Reader) sequentialSearchChunk(chunk []byte, searchKey []byte) (*encoder.EncodedValue, error) {
-	var prefixKey []byte
-	var offset int
-	for {
-		var keyLen, valLen uint64
-		sharedLen, n := binary.Uvarint(chunk[offset:])
-		if n <= 0 {
-			break // EOF
-		}
-		offset += n
-		keyLen, n = binary.Uvarint(chunk[offset:])
-		offset += n
-		valLen, n = binary.Uvarint(chunk[offset:])
-		offset += n
-
-		key := r.buf[:sharedLen+keyLen]
-		if sharedLen == 0 {
-			prefixKey = key
-		}
-		copy(key[:sharedLen], prefixKey[:sharedLen])
-		copy(key[sharedLen:sharedLen+keyLen], chunk[offset:offset+int(keyLen)])
-		val := chunk[offset+int(keyLen) : offset+int(keyLen)+int(valLen)]
-
-		cmp := bytes.Compare(searchKey, key)
-		if cmp == 0 {
-			return r.encoder.Parse(val), nil
-		}
-		if cmp < 0 {
-			break // Key is not present in this data chunk.
+// Get returns the most recent version of "key" whose sequence number does not exceed "seqNum".
+// The filter block is consulted first, so that most absent keys are rejected without reading any data block.
+func (r *Reader) Get(key []byte, seqNum uint64) (*encoder.EncodedValue, error) {
+	if !r.filter.MayContain(key) {
+		return nil, ErrKeyNotFound
+	}
+	i := r.newIterator()
+	if !i.SeekGE(r.encoder.EncodeKey(key, seqNum)) {
+		if i.err != nil {
+			return nil, i.err
 		}
-		offset += int(keyLen) + int(valLen)
+		return nil, ErrKeyNotFound
+	}
+	if r.cmp.Compare(encoder.UserKey(i.key), key) != 0 {
+		return nil, ErrKeyNotFound
 	}
-	return nil, ErrKeyNotFound
+	return r.encoder.Parse(i.val), nil
 }
 
-// Read the *.sst footer into the supplied buffer.
-func (r *Reader) readFooter() ([]byte, error) {
-	buf := r.buf[:footerSizeInBytes]
-	footerOffset := r.fileSize - footerSizeInBytes
+// Read the *.sst footer and locate the filter, index, and properties blocks.
+func (r *Reader) readFooter() error {
+	buf := r.buf[:min(tableFooterSize, r.fileSize)]
+	footerOffset := r.fileSize - int64(len(buf))
 	_, err := r.file.ReadAt(buf, footerOffset)
 	if err != nil {
-		return nil, err
-	}
-	return buf, nil
-}
-
-func (r *Reader) prepareBlockReader(buf, footer []byte) *blockReader {
-	indexLength := int(binary.LittleEndian.Uint32(footer[:4]))
-	numOffsets := int(binary.LittleEndian.Uint32(footer[4:]))
-	buf = buf[:indexLength]
-	return &blockReader{
-		buf:        buf,
-		offsets:    buf[indexLength-(numOffsets+2)*offsetSizeInBytes:],
-		numOffsets: numOffsets,
+		return err
 	}
-}
-
-func (r *Reader) readIndexBlock(footer []byte) (*blockReader, error) {
-	b := r.prepareBlockReader(r.buf, footer)
-	indexOffset := r.fileSize - int64(len(b.buf))
-	_, err := r.file.ReadAt(b.buf, indexOffset)
+	footer, reason, err := decodeFooter(buf, r.fileSize)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return b, nil
+	if reason != "" {
+		return r.corruption(footerOffset, reason)
+	}
+	r.footer = footer
+	return nil
 }
 
-func (r *Reader) readDataBlock(indexEntry []byte) (*blockReader, error) {
-	var err error
-	val := r.encoder.Parse(indexEntry).Value()
-	offset := binary.LittleEndian.Uint32(val[:4]) // data block offset in *.sst file
-	length := binary.LittleEndian.Uint32(val[4:]) // data block length
-	buf := r.buf[:length]
-	_, err = r.file.ReadAt(buf, int64(offset))
+// readBlock reads the block referenced by "h", verifies its checksum, and returns its decompressed contents.
+func (r *Reader) readBlock(h blockHandle) ([]byte, error) {
+	contents, c, err := r.readRawBlock(h)
 	if err != nil {
 		return nil, err
 	}
-	buf, err = snappy.Decode(r.compressionBuf, buf)
+	contents, err = decompressBlock(contents, c)
 	if err != nil {
-		return nil, err
+		return nil, r.corruption(int64(h.offset), err.Error())
 	}
-	b := r.prepareBlockReader(buf, buf[len(buf)-footerSizeInBytes:])
-	return b, nil
+	return contents, nil
 }
 
-func (r *Reader) binarySearch(searchKey []byte) (*encoder.EncodedValue, error) {
-	footer, err := r.readFooter()
+// readRawBlock reads the block referenced by "h", verifies its checksum, and returns its contents as stored together
+// with their compression type.
+func (r *Reader) readRawBlock(h blockHandle) ([]byte, Compression, error) {
+	if int64(h.offset)+int64(h.length)+BlockTrailerSize > r.footer.offset {
+		return nil, 0, r.corruption(int64(h.offset), "block handle out of bounds")
+	}
+	buf := make([]byte, h.length+BlockTrailerSize)
+	_, err := r.file.ReadAt(buf, int64(h.offset))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	contents, c, ok := verifyBlock(buf)
+	if !ok {
+		return nil, 0, r.corruption(int64(h.offset), "block checksum mismatch")
 	}
+	return contents, c, nil
+}
 
-	// Search index block for data block.
-	index, err := r.readIndexBlock(footer)
+// readDataBlock returns the decompressed contents of the data block referenced by "h", consulting the block cache
+// before reading from the file.
+func (r *Reader) readDataBlock(h blockHandle) ([]byte, error) {
+	if r.cache != nil {
+		if buf, ok := r.cache.get(r.fileNum, h.offset); ok {
+			return buf, nil
+		}
+	}
+	buf, err := r.readBlock(h)
 	if err != nil {
 		return nil, err
 	}
-	pos := index.search(searchKey, moveUpWhenKeyGT)
-	if pos >= index.numOffsets {
-		// searchKey is greater than the largest key in the current *.sst
-		return nil, ErrKeyNotFound
+	if r.cache != nil {
+		r.cache.add(r.fileNum, h.offset, buf)
 	}
-	indexEntry := index.readValAt(pos)
+	return buf, nil
+}
 
-	// Search data block for data chunk.
-	data, err := r.readDataBlock(indexEntry)
-	if err != nil {
-		return nil, err
+// prepareBlockReader parses the footer of block "buf", located by "h", that was produced by a blockWriter.
+func (r *Reader) prepareBlockReader(buf []byte, h blockHandle) (*blockReader, error) {
+	if len(buf) < footerSizeInBytes {
+		return nil, r.corruption(int64(h.offset), "block too short")
 	}
-	offset := data.search(searchKey, moveUpWhenKeyGTE)
-	if offset <= 0 {
-		return nil, ErrKeyNotFound
+	footer := buf[len(buf)-footerSizeInBytes:]
+	length := int(binary.LittleEndian.Uint32(footer[:4]))
+	numOffsets := int(binary.LittleEndian.Uint32(footer[4:]))
+	if length != len(buf) || numOffsets > length/offsetSizeInBytes-2 {
+		return nil, r.corruption(int64(h.offset), "malformed block footer")
 	}
-	chunkStart := data.readOffsetAt(offset - 1)
-	chunkEnd := data.readOffsetAt(offset)
-	chunk := data.buf[chunkStart:chunkEnd]
+	return &blockReader{
+		buf:        buf,
+		offsets:    buf[length-(numOffsets+2)*offsetSizeInBytes:],
+		numOffsets: numOffsets,
+	}, nil
+}
 
-	// Search data chunk for key.
-	return r.sequentialSearchChunk(chunk, searchKey)
+func (r *Reader) corruption(offset int64, reason string) error {
+	return &CorruptionError{FileNum: r.fileNum, Offset: offset, Reason: reason}
 }
 
 func (r *Reader) Close() error {
@@ -219,6 +221,5 @@ func (r *Reader) Close() error {
 		return err
 	}
 	r.file = nil
-	r.br = nil
 	return nil
 }
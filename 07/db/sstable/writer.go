@@ -2,19 +2,69 @@ package sstable
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"io"
+	"math"
+	"time"
 
+	"github.com/cloudcentricdev/golang-tutorials/07/db/bloom"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
 	"github.com/cloudcentricdev/golang-tutorials/07/db/memtable"
-	"github.com/golang/snappy"
 )
 
 const (
-	dataBlockChunkSize  = 16
-	indexBlockChunkSize = 1
+	DefaultMaxBlockSize       = 4096
+	DefaultDataBlockChunkSize = 16
+	indexBlockChunkSize       = 1
+	filterBitsPerKey          = 10
 )
 
+// WriterOptions configures the layout of the *.sst files produced by a Writer. Zero fields select the defaults.
+// Readers don't depend on these options, so *.sst files written with different options can be mixed freely.
+type WriterOptions struct {
+	MaxBlockSize        int // expected maximum size of an uncompressed data block (in bytes)
+	BlockFlushThreshold int // size at which the data block in progress is completed (in bytes), 90% of MaxBlockSize by default
+	DataBlockChunkSize  int // number of entries per prefix-compressed chunk of a data block
+
+	Compression Compression // codec applied to data blocks
+	// MinCompressionSavings is the fraction of its size a data block must shrink by for it to be stored compressed.
+	// Unlike for the other fields, nil rather than zero selects the default, as zero stores every block compressed
+	// that shrinks at all.
+	MinCompressionSavings *float64
+
+	Comparer comparer.Comparer // order of the user keys, also used to shorten the keys of the index block
+}
+
+func (o WriterOptions) withDefaults() WriterOptions {
+	if o.MaxBlockSize == 0 {
+		o.MaxBlockSize = DefaultMaxBlockSize
+	}
+	if o.BlockFlushThreshold == 0 {
+		o.BlockFlushThreshold = DefaultBlockFlushThreshold(o.MaxBlockSize)
+	}
+	if o.DataBlockChunkSize == 0 {
+		o.DataBlockChunkSize = DefaultDataBlockChunkSize
+	}
+	if o.Compression == DefaultCompression {
+		o.Compression = SnappyCompression
+	}
+	if o.MinCompressionSavings == nil {
+		minSavings := DefaultMinCompressionSavings
+		o.MinCompressionSavings = &minSavings
+	}
+	if o.Comparer == nil {
+		o.Comparer = comparer.Default
+	}
+	return o
+}
+
+// DefaultBlockFlushThreshold returns the block flush threshold used for data blocks of at most "maxBlockSize" bytes.
+func DefaultBlockFlushThreshold(maxBlockSize int) int {
+	return int(math.Floor(float64(maxBlockSize) * 0.9))
+}
+
 type syncCloser interface {
 	io.Closer
 	Sync() error
@@ -24,6 +74,7 @@ type Writer struct {
 	file syncCloser
 	bw   *bufio.Writer
 	buf  []byte
+	opts WriterOptions
 
 	dataBlock  *blockWriter
 	indexBlock *blockWriter
@@ -32,16 +83,23 @@ type Writer struct {
 	offset       int    // offset of current data block.
 	bytesWritten int    // bytesWritten to current data block.
 	lastKey      []byte // lastKey in current data block
+	keyHashes    []uint32
+
+	// the index entry of a completed data block is only added once the first key of the next data block is known
+	pendingIndexEntry bool
+	pendingHandle     blockHandle
 
 	compressionBuf []byte
+	props          Properties
 }
 
-func NewWriter(file io.Writer) *Writer {
-	w := &Writer{}
+func NewWriter(file io.Writer, opts WriterOptions) *Writer {
+	w := &Writer{opts: opts.withDefaults()}
 	bw := bufio.NewWriter(file)
 	w.file, w.bw = file.(syncCloser), bw
 	w.buf = make([]byte, 0, 1024)
-	w.dataBlock, w.indexBlock = newBlockWriter(dataBlockChunkSize), newBlockWriter(indexBlockChunkSize)
+	w.dataBlock = newBlockWriter(w.opts.DataBlockChunkSize, w.opts.MaxBlockSize)
+	w.indexBlock = newBlockWriter(indexBlockChunkSize, w.opts.MaxBlockSize)
 
 	return w
 }
@@ -50,35 +108,93 @@ func (w *Writer) Process(m *memtable.Memtable) error {
 	i := m.Iterator()
 	for i.HasNext() {
 		key, val := i.Next()
-		n, err := w.dataBlock.add(key, val)
-		if err != nil {
+		if err := w.Add(key, val); err != nil {
 			return err
 		}
-		w.bytesWritten += n
-		w.lastKey = key
-
-		if w.bytesWritten > blockFlushThreshold {
-			err = w.flushDataBlock()
-			if err != nil {
-				return err
-			}
+	}
+	return w.Finish()
+}
+
+// Add appends a key-value pair to the *.sst file. Keys must be added in strictly ascending order.
+func (w *Writer) Add(key, val []byte) error {
+	if w.pendingIndexEntry {
+		if err := w.addIndexEntry(w.indexKey(key)); err != nil {
+			return err
 		}
 	}
+	n, err := w.dataBlock.add(key, val)
+	if err != nil {
+		return err
+	}
+	// the filter is built from user keys, as lookups may ask for any version of a key
+	userKey := encoder.UserKey(key)
+	if len(w.keyHashes) == 0 || !bytes.Equal(userKey, encoder.UserKey(w.lastKey)) {
+		w.keyHashes = append(w.keyHashes, bloom.Hash(userKey))
+	}
+	w.bytesWritten += n
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.props.add(key, val)
+
+	if w.bytesWritten > w.opts.BlockFlushThreshold {
+		err = w.flushDataBlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish flushes the last data block and appends the filter block, the index block, the properties block, and the
+// footer to the *.sst file.
+func (w *Writer) Finish() error {
 	err := w.flushDataBlock()
 	if err != nil {
 		return err
 	}
+	if w.pendingIndexEntry {
+		if err = w.addIndexEntry(w.indexKey(nil)); err != nil {
+			return err
+		}
+	}
+	footer := &tableFooter{version: formatVersion}
+
+	filter := bloom.NewFilter(w.keyHashes, filterBitsPerKey)
+	if footer.filter, err = w.writeBlock(filter, NoCompression); err != nil {
+		return err
+	}
+
 	err = w.indexBlock.finish()
 	if err != nil {
 		return err
 	}
-	_, err = w.bw.ReadFrom(w.indexBlock.buf)
+	if footer.index, err = w.writeBlock(w.indexBlock.buf.Bytes(), NoCompression); err != nil {
+		return err
+	}
+
+	w.props.LargestKey = w.lastKey
+	w.props.Compression = w.opts.Compression.String()
+	w.props.CreationTime = time.Now()
+	w.props.ComparerName = w.opts.Comparer.Name()
+	props, err := w.props.encode()
 	if err != nil {
 		return err
 	}
+	if footer.properties, err = w.writeBlock(props, NoCompression); err != nil {
+		return err
+	}
+
+	if _, err = w.bw.Write(footer.encode()); err != nil {
+		return err
+	}
+	w.offset += tableFooterSize
 	return nil
 }
 
+// Size returns the approximate size of the *.sst file (in bytes), counting the uncompressed size of the data block in progress.
+func (w *Writer) Size() int {
+	return w.offset + w.bytesWritten
+}
+
 func (w *Writer) flushDataBlock() error {
 	if w.bytesWritten <= 0 {
 		return nil // nothing to flush
@@ -87,29 +203,63 @@ func (w *Writer) flushDataBlock() error {
 	if err != nil {
 		return err
 	}
-	w.compressionBuf = snappy.Encode(w.compressionBuf, w.dataBlock.buf.Bytes())
-	w.dataBlock.buf.Reset()
-	_, err = w.bw.Write(w.compressionBuf)
+	var c Compression
+	w.compressionBuf, c, err = compressBlock(w.compressionBuf[:0], w.dataBlock.buf.Bytes(), w.opts.Compression, *w.opts.MinCompressionSavings)
 	if err != nil {
 		return err
 	}
-	err = w.addIndexEntry()
+	w.dataBlock.buf.Reset()
+	w.pendingHandle, err = w.writeBlock(w.compressionBuf, c)
 	if err != nil {
 		return err
 	}
-	w.offset += len(w.compressionBuf)
+	w.pendingIndexEntry = true
 	w.bytesWritten = 0
+	w.props.DataSize += uint64(w.pendingHandle.length) + BlockTrailerSize
+	w.props.NumDataBlocks++
 	return nil
 }
 
-func (w *Writer) addIndexEntry() error {
+// writeBlock appends "contents" compressed with "c", followed by the block trailer, to the *.sst file.
+func (w *Writer) writeBlock(contents []byte, c Compression) (blockHandle, error) {
+	h := blockHandle{offset: uint32(w.offset), length: uint32(len(contents))}
+	if _, err := w.bw.Write(contents); err != nil {
+		return h, err
+	}
+	if _, err := w.bw.Write(appendBlockTrailer(w.buf[:0], contents, c)); err != nil {
+		return h, err
+	}
+	w.offset += len(contents) + BlockTrailerSize
+	return h, nil
+}
+
+// indexKey returns a key that separates the last key of the completed data block from "nextKey", the first key of the
+// subsequent data block. A nil "nextKey" marks the last data block of the *.sst file.
+func (w *Writer) indexKey(nextKey []byte) []byte {
+	userKey := encoder.UserKey(w.lastKey)
+	var short []byte
+	if nextKey == nil {
+		short = w.opts.Comparer.Successor(nil, userKey)
+	} else {
+		short = w.opts.Comparer.Separator(nil, userKey, encoder.UserKey(nextKey))
+	}
+	// the shortened user key sorts after every version of the last key, but before every version of the next key
+	if len(short) < len(userKey) && w.opts.Comparer.Compare(userKey, short) < 0 {
+		return w.encoder.EncodeKey(short, encoder.MaxSeqNum)
+	}
+	return w.lastKey
+}
+
+func (w *Writer) addIndexEntry(key []byte) error {
+	h := w.pendingHandle
 	buf := w.buf[:8]
-	binary.LittleEndian.PutUint32(buf[:4], uint32(w.offset))              // data block offset
-	binary.LittleEndian.PutUint32(buf[4:], uint32(len(w.compressionBuf))) // data block length
-	_, err := w.indexBlock.add(w.lastKey, w.encoder.Encode(encoder.OpKindSet, buf))
+	binary.LittleEndian.PutUint32(buf[:4], h.offset) // data block offset
+	binary.LittleEndian.PutUint32(buf[4:], h.length) // data block length
+	_, err := w.indexBlock.add(key, w.encoder.Encode(encoder.OpKindSet, buf))
 	if err != nil {
 		return err
 	}
+	w.pendingIndexEntry = false
 	return nil
 }
 
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+const lockFileName = "LOCK"
+
+var ErrLocked = vfs.ErrLocked
+
+// Lock takes an exclusive advisory lock on the LOCK file in the data directory, so that no other process can use the
+// directory at the same time. ErrLocked is returned right away if another process holds the lock.
+func (s *Provider) Lock() error {
+	path := filepath.Join(s.dataDir, lockFileName)
+	lock, err := s.fs.Lock(path)
+	if err != nil {
+		if errors.Is(err, ErrLocked) {
+			return fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		return err
+	}
+	s.lock = lock
+	return nil
+}
+
+// Unlock releases the lock taken by Lock. The LOCK file itself is left in place, as removing it would allow another
+// process to lock a new LOCK file while the old one is still locked by a third process.
+func (s *Provider) Unlock() error {
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Close()
+	s.lock = nil
+	return err
+}
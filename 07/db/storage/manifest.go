@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+const currentFileName = "CURRENT"
+
+// manifest record header: payload length (4 bytes) followed by a CRC32C of the payload (4 bytes)
+const manifestHeaderSize = 8
+
+const (
+	tagLogNum = iota + 1
+	tagNextFileNum
+	tagDeletedFile
+	tagNewFile
+	tagLastSeqNum
+)
+
+var ErrManifestCorrupted = errors.New("manifest corrupted")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewFileEntry describes a table added to a level of the LSM tree.
+type NewFileEntry struct {
+	Level int
+	Meta  *FileMetadata
+}
+
+// DeletedFileEntry describes a table removed from a level of the LSM tree.
+type DeletedFileEntry struct {
+	Level   int
+	FileNum int
+}
+
+// VersionEdit records the difference between two consecutive versions of the LSM tree. The MANIFEST is a log of
+// version edits, and replaying it from the beginning reconstructs the set of live files.
+type VersionEdit struct {
+	LogNum      int // WAL files with a lower number hold no data that is missing from the sstables
+	NextFileNum int // file number to be handed out next
+	LastSeqNum  uint64
+	Deleted     []DeletedFileEntry
+	Added       []NewFileEntry
+}
+
+func (e *VersionEdit) encode() []byte {
+	var buf []byte
+	if e.LogNum != 0 {
+		buf = binary.AppendUvarint(buf, tagLogNum)
+		buf = binary.AppendUvarint(buf, uint64(e.LogNum))
+	}
+	if e.NextFileNum != 0 {
+		buf = binary.AppendUvarint(buf, tagNextFileNum)
+		buf = binary.AppendUvarint(buf, uint64(e.NextFileNum))
+	}
+	if e.LastSeqNum != 0 {
+		buf = binary.AppendUvarint(buf, tagLastSeqNum)
+		buf = binary.AppendUvarint(buf, e.LastSeqNum)
+	}
+	for _, d := range e.Deleted {
+		buf = binary.AppendUvarint(buf, tagDeletedFile)
+		buf = binary.AppendUvarint(buf, uint64(d.Level))
+		buf = binary.AppendUvarint(buf, uint64(d.FileNum))
+	}
+	for _, a := range e.Added {
+		buf = binary.AppendUvarint(buf, tagNewFile)
+		buf = binary.AppendUvarint(buf, uint64(a.Level))
+		buf = binary.AppendUvarint(buf, uint64(a.Meta.fileNum))
+		buf = binary.AppendUvarint(buf, uint64(a.Meta.size))
+		buf = appendBytes(buf, a.Meta.smallest)
+		buf = appendBytes(buf, a.Meta.largest)
+	}
+	return buf
+}
+
+func appendBytes(buf, p []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(p)))
+	return append(buf, p...)
+}
+
+func (e *VersionEdit) decode(buf []byte) error {
+	d := &editDecoder{buf: buf}
+	for len(d.buf) > 0 && d.err == nil {
+		switch tag := d.uvarint(); tag {
+		case tagLogNum:
+			e.LogNum = int(d.uvarint())
+		case tagNextFileNum:
+			e.NextFileNum = int(d.uvarint())
+		case tagLastSeqNum:
+			e.LastSeqNum = d.uvarint()
+		case tagDeletedFile:
+			level, fileNum := int(d.uvarint()), int(d.uvarint())
+			e.Deleted = append(e.Deleted, DeletedFileEntry{Level: level, FileNum: fileNum})
+		case tagNewFile:
+			level := int(d.uvarint())
+			meta := &FileMetadata{fileType: FileTypeSSTable}
+			meta.fileNum = int(d.uvarint())
+			meta.size = int64(d.uvarint())
+			meta.smallest = d.bytes()
+			meta.largest = d.bytes()
+			e.Added = append(e.Added, NewFileEntry{Level: level, Meta: meta})
+		default:
+			return fmt.Errorf("%w: unknown tag %d", ErrManifestCorrupted, tag)
+		}
+	}
+	return d.err
+}
+
+type editDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *editDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrManifestCorrupted
+		d.buf = nil
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *editDecoder) bytes() []byte {
+	n := d.uvarint()
+	if uint64(len(d.buf)) < n {
+		d.err = ErrManifestCorrupted
+		d.buf = nil
+		return nil
+	}
+	p := make([]byte, n)
+	copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return p
+}
+
+// Manifest appends version edits to the active MANIFEST file.
+type Manifest struct {
+	provider *Provider
+	file     vfs.File
+	meta     *FileMetadata
+}
+
+// ReadManifest replays the MANIFEST file referenced by CURRENT and returns the version edits recorded in it. A record
+// torn by a crash at the tail of the MANIFEST is treated as never written. A nil slice is returned for a new database.
+func (s *Provider) ReadManifest() ([]*VersionEdit, error) {
+	current, err := vfs.ReadFile(s.fs, filepath.Join(s.dataDir, currentFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	name := strings.TrimSuffix(string(current), "\n")
+	buf, err := vfs.ReadFile(s.fs, filepath.Join(s.dataDir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []*VersionEdit
+	for offset := 0; offset < len(buf); {
+		if len(buf)-offset < manifestHeaderSize {
+			break // torn record header
+		}
+		length := int(binary.LittleEndian.Uint32(buf[offset:]))
+		checksum := binary.LittleEndian.Uint32(buf[offset+4:])
+		start, end := offset+manifestHeaderSize, offset+manifestHeaderSize+length
+		if end > len(buf) {
+			break // torn record payload
+		}
+		if crc32.Checksum(buf[start:end], crcTable) != checksum {
+			if end == len(buf) {
+				break // torn final record
+			}
+			return nil, fmt.Errorf("%w: checksum mismatch in %s at offset %d", ErrManifestCorrupted, name, offset)
+		}
+		edit := &VersionEdit{}
+		if err = edit.decode(buf[start:end]); err != nil {
+			return nil, err
+		}
+		if edit.NextFileNum > s.fileNum {
+			s.fileNum = edit.NextFileNum - 1
+		}
+		edits = append(edits, edit)
+		offset = end
+	}
+	if len(edits) == 0 {
+		return nil, fmt.Errorf("%w: %s holds no records", ErrManifestCorrupted, name)
+	}
+	return edits, nil
+}
+
+// CreateManifest starts a new MANIFEST file containing "snapshot" and atomically points CURRENT to it.
+// The previously active MANIFEST file becomes obsolete and can be deleted afterward.
+func (s *Provider) CreateManifest(snapshot *VersionEdit) (*Manifest, error) {
+	meta := s.prepareNewFile(FileTypeManifest)
+	file, err := s.OpenFileForWriting(meta)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{provider: s, file: file, meta: meta}
+	if err = m.Append(snapshot); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err = s.setCurrentFile(meta); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// setCurrentFile atomically points CURRENT to the MANIFEST file described by "meta".
+func (s *Provider) setCurrentFile(meta *FileMetadata) error {
+	return s.writeFileAtomically(currentFileName, []byte(s.makeFileName(meta.fileNum, meta.fileType)+"\n"))
+}
+
+// writeFileAtomically replaces the contents of file "name" by writing a temporary file and renaming it over the old one.
+func (s *Provider) writeFileAtomically(name string, data []byte) error {
+	tmpPath := filepath.Join(s.dataDir, name+tempFileSuffix)
+	f, err := s.fs.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return err
+	}
+	if err = s.fs.Rename(tmpPath, filepath.Join(s.dataDir, name)); err != nil {
+		return err
+	}
+	return s.SyncDataDir()
+}
+
+// Append durably records "edit" in the MANIFEST file, stamping it with the next file number to be handed out.
+func (m *Manifest) Append(edit *VersionEdit) error {
+	edit.NextFileNum = m.provider.lastFileNum() + 1
+	payload := edit.encode()
+	buf := make([]byte, manifestHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(payload, crcTable))
+	copy(buf[manifestHeaderSize:], payload)
+	if _, err := m.file.Write(buf); err != nil {
+		return err
+	}
+	return m.file.Sync()
+}
+
+// FileNum returns the file number of the active MANIFEST file.
+func (m *Manifest) FileNum() int {
+	return m.meta.fileNum
+}
+
+func (m *Manifest) Close() error {
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+const optionsFileName = "OPTIONS"
+
+// ReadOptions returns the settings recorded in the OPTIONS file, or nil for a database that has none yet.
+func (s *Provider) ReadOptions() (map[string]string, error) {
+	buf, err := vfs.ReadFile(s.fs, filepath.Join(s.dataDir, optionsFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed line in %s: %q", optionsFileName, line)
+		}
+		settings[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return settings, scanner.Err()
+}
+
+// WriteOptions atomically replaces the OPTIONS file with "settings", written as one "name=value" line per setting.
+func (s *Provider) WriteOptions(settings map[string]string) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s=%s\n", name, settings[name])
+	}
+	return s.writeFileAtomically(optionsFileName, buf.Bytes())
+}
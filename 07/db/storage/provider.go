@@ -2,15 +2,28 @@ package storage
 
 import (
 	"cmp"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"io/fs"
 	"path/filepath"
 	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
 )
 
+// tempFileSuffix marks files that are still being written. They are renamed into place once complete, so any such
+// file found in the data directory was left behind by a crash.
+const tempFileSuffix = ".tmp"
+
 type Provider struct {
+	fs      vfs.FS
 	dataDir string
+	mu      sync.Mutex // protects fileNum, as files are created by writers and background work alike
 	fileNum int
+	lock    io.Closer // holds the lock on the data directory, see Lock
 }
 
 type FileType int
@@ -19,11 +32,15 @@ const (
 	FileTypeUnknown FileType = iota
 	FileTypeSSTable
 	FileTypeWAL
+	FileTypeManifest
 )
 
 type FileMetadata struct {
 	fileNum  int
 	fileType FileType
+	size     int64
+	smallest []byte
+	largest  []byte
 }
 
 func (f *FileMetadata) IsSSTable() bool {
@@ -34,12 +51,36 @@ func (f *FileMetadata) IsWAL() bool {
 	return f.fileType == FileTypeWAL
 }
 
+func (f *FileMetadata) IsManifest() bool {
+	return f.fileType == FileTypeManifest
+}
+
 func (f *FileMetadata) FileNum() int {
 	return f.fileNum
 }
 
-func NewProvider(dataDir string) (*Provider, error) {
-	s := &Provider{dataDir: dataDir}
+func (f *FileMetadata) Size() int64 {
+	return f.size
+}
+
+func (f *FileMetadata) Smallest() []byte {
+	return f.smallest
+}
+
+func (f *FileMetadata) Largest() []byte {
+	return f.largest
+}
+
+// Describe records the size and the key range of a fully written *.sst file.
+func (f *FileMetadata) Describe(size int64, smallest, largest []byte) {
+	f.size = size
+	f.smallest = smallest
+	f.largest = largest
+}
+
+// NewProvider manages the files of the data directory "dataDir" on file system "fsys", creating the directory if needed.
+func NewProvider(fsys vfs.FS, dataDir string) (*Provider, error) {
+	s := &Provider{fs: fsys, dataDir: dataDir}
 
 	err := s.ensureDataDirExists()
 	if err != nil {
@@ -50,32 +91,34 @@ func NewProvider(dataDir string) (*Provider, error) {
 }
 
 func (s *Provider) ensureDataDirExists() error {
-	err := os.MkdirAll(s.dataDir, 0755)
+	err := s.fs.MkdirAll(s.dataDir)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// ListFiles returns the metadata of every *.sst, *.log and MANIFEST file in the data directory, sorted by file number.
+// Temporary files left behind by a crash are deleted, while files whose names follow none of the patterns used by the
+// database are ignored.
 func (s *Provider) ListFiles() ([]*FileMetadata, error) {
-	files, err := os.ReadDir(s.dataDir)
+	files, err := s.fs.List(s.dataDir)
 	if err != nil {
 		return nil, err
 	}
 	var meta []*FileMetadata
-	var fileNumber int
-	var fileExtension string
-	for _, f := range files {
-		_, err = fmt.Sscanf(f.Name(), "%06d.%s", &fileNumber, &fileExtension)
-		if err != nil {
-			return nil, err
+	for _, name := range files {
+		if fileNumber, ok := s.isTempFile(name); ok {
+			if err = s.fs.Remove(filepath.Join(s.dataDir, name)); err != nil {
+				return nil, err
+			}
+			// the number of a half-written file is never handed out again
+			s.fileNum = max(s.fileNum, fileNumber)
+			continue
 		}
-		fileType := FileTypeUnknown
-		switch fileExtension {
-		case "sst":
-			fileType = FileTypeSSTable
-		case "log":
-			fileType = FileTypeWAL
+		fileNumber, fileType, ok := parseFileName(name)
+		if !ok {
+			continue
 		}
 		meta = append(meta, &FileMetadata{
 			fileNum:  fileNumber,
@@ -91,17 +134,60 @@ func (s *Provider) ListFiles() ([]*FileMetadata, error) {
 	return meta, nil
 }
 
+func parseFileName(name string) (fileNumber int, fileType FileType, ok bool) {
+	var fileExtension string
+	if n, _ := fmt.Sscanf(name, "MANIFEST-%06d", &fileNumber); n == 1 {
+		return fileNumber, FileTypeManifest, name == fmt.Sprintf("MANIFEST-%06d", fileNumber)
+	}
+	if n, _ := fmt.Sscanf(name, "%06d.%s", &fileNumber, &fileExtension); n != 2 {
+		return 0, FileTypeUnknown, false
+	}
+	switch fileExtension {
+	case "sst":
+		fileType = FileTypeSSTable
+	case "log":
+		fileType = FileTypeWAL
+	default:
+		return 0, FileTypeUnknown, false
+	}
+	return fileNumber, fileType, name == fmt.Sprintf("%06d.%s", fileNumber, fileExtension)
+}
+
+// isTempFile reports whether "name" is a temporary file created by the database, along with its file number (zero for
+// the temporary files of CURRENT and OPTIONS).
+func (s *Provider) isTempFile(name string) (fileNumber int, ok bool) {
+	name, ok = strings.CutSuffix(name, tempFileSuffix)
+	if !ok {
+		return 0, false
+	}
+	if name == currentFileName || name == optionsFileName {
+		return 0, true
+	}
+	fileNumber, _, ok = parseFileName(name)
+	return fileNumber, ok
+}
+
 func (s *Provider) nextFileNum() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.fileNum++
 	return s.fileNum
 }
 
+func (s *Provider) lastFileNum() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fileNum
+}
+
 func (s *Provider) makeFileName(fileNumber int, fileType FileType) string {
 	switch fileType {
 	case FileTypeSSTable:
 		return fmt.Sprintf("%06d.sst", fileNumber)
 	case FileTypeWAL:
 		return fmt.Sprintf("%06d.log", fileNumber)
+	case FileTypeManifest:
+		return fmt.Sprintf("MANIFEST-%06d", fileNumber)
 	case FileTypeUnknown:
 	}
 	panic("unknown file type")
@@ -122,31 +208,74 @@ func (s *Provider) PrepareNewWALFile() *FileMetadata {
 	return s.prepareNewFile(FileTypeWAL)
 }
 
-func (s *Provider) OpenFileForWriting(meta *FileMetadata) (*os.File, error) {
-	const openFlags = os.O_RDWR | os.O_CREATE | os.O_EXCL
+// OpenFileForWriting creates the file described by "meta", which must not exist yet, and syncs the data directory, so
+// that the file survives a crash from the start. It suits files that are appended to over their lifetime, such as WAL and MANIFEST files.
+func (s *Provider) OpenFileForWriting(meta *FileMetadata) (vfs.File, error) {
 	filename := s.makeFileName(meta.fileNum, meta.fileType)
-	file, err := os.OpenFile(filepath.Join(s.dataDir, filename), openFlags, 0644)
+	file, err := s.fs.CreateExclusive(filepath.Join(s.dataDir, filename))
+	if err != nil {
+		return nil, err
+	}
+	if err = s.SyncDataDir(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// OpenTempFileForWriting creates a temporary file, which must not exist yet, for the file described by "meta". The
+// file only appears under its final name once it has been written, synced, closed, and passed to CommitTempFile, which
+// keeps half-written files from being mistaken for complete ones after a crash.
+func (s *Provider) OpenTempFileForWriting(meta *FileMetadata) (vfs.File, error) {
+	filename := s.makeFileName(meta.fileNum, meta.fileType) + tempFileSuffix
+	file, err := s.fs.CreateExclusive(filepath.Join(s.dataDir, filename))
 	if err != nil {
 		return nil, err
 	}
 	return file, nil
 }
 
-func (s *Provider) OpenFileForReading(meta *FileMetadata) (*os.File, error) {
-	const openFlags = os.O_RDONLY
+// CommitTempFile renames the temporary file created by OpenTempFileForWriting into place and syncs the data directory.
+func (s *Provider) CommitTempFile(meta *FileMetadata) error {
 	filename := s.makeFileName(meta.fileNum, meta.fileType)
-	file, err := os.OpenFile(filepath.Join(s.dataDir, filename), openFlags, 0)
+	path := filepath.Join(s.dataDir, filename)
+	if err := s.fs.Rename(path+tempFileSuffix, path); err != nil {
+		return err
+	}
+	return s.SyncDataDir()
+}
+
+// RemoveTempFile deletes the temporary file created by OpenTempFileForWriting, e.g., after writing it failed.
+func (s *Provider) RemoveTempFile(meta *FileMetadata) error {
+	filename := s.makeFileName(meta.fileNum, meta.fileType) + tempFileSuffix
+	err := s.fs.Remove(filepath.Join(s.dataDir, filename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *Provider) OpenFileForReading(meta *FileMetadata) (vfs.File, error) {
+	filename := s.makeFileName(meta.fileNum, meta.fileType)
+	file, err := s.fs.Open(filepath.Join(s.dataDir, filename))
 	if err != nil {
 		return nil, err
 	}
 	return file, nil
 }
+
+// DeleteFile removes the file described by "meta". The data directory is not synced, see SyncDataDir.
 func (s *Provider) DeleteFile(meta *FileMetadata) error {
 	name := s.makeFileName(meta.fileNum, meta.fileType)
 	path := filepath.Join(s.dataDir, name)
-	err := os.Remove(path)
-	if os.IsNotExist(err) {
+	err := s.fs.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
 		return nil
 	}
 	return err
 }
+
+// SyncDataDir makes any files created, renamed, or deleted in the data directory durable.
+func (s *Provider) SyncDataDir() error {
+	return s.fs.SyncDir(s.dataDir)
+}
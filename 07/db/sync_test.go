@@ -0,0 +1,101 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/wal"
+)
+
+func TestApplySync(t *testing.T) {
+	discardLogs(t)
+	mem := vfs.NewMem()
+	fs := vfs.NewFaultFS(mem)
+	d, err := Open("db", &Options{FS: fs, WALSyncPolicy: wal.SyncPolicy{Mode: wal.SyncNever}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(key string, opts *WriteOptions) {
+		var b Batch
+		b.Set([]byte(key), []byte("val"))
+		if err := d.Apply(&b, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply("a", nil)
+	apply("b", &WriteOptions{Sync: true})
+	apply("c", nil)
+	if err = fs.Crash(); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	if d, err = Open("db", &Options{FS: mem}); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	// the synced write makes the one before it durable as well, while the one after it is lost with the crash
+	for key, want := range map[string]bool{"a": true, "b": true, "c": false} {
+		if _, err := d.Get([]byte(key)); (err == nil) != want {
+			t.Errorf("%s: got %v, want present = %v", key, err, want)
+		}
+	}
+}
+
+func TestSyncPolicyValidation(t *testing.T) {
+	tests := []struct {
+		policy wal.SyncPolicy
+		valid  bool
+	}{
+		{wal.SyncPolicy{Mode: wal.SyncEveryWrite}, true},
+		{wal.SyncPolicy{Mode: wal.SyncNever}, true},
+		{wal.SyncPolicy{Mode: wal.SyncPeriodically, Bytes: 1 << 10}, true},
+		{wal.SyncPolicy{Mode: wal.SyncPeriodically, Interval: 1}, true},
+		{wal.SyncPolicy{Mode: wal.SyncPeriodically}, false},
+		{wal.SyncPolicy{Mode: wal.SyncPeriodically, Bytes: -1}, false},
+	}
+	for _, tt := range tests {
+		err := (&Options{WALSyncPolicy: tt.policy}).withDefaults().validate()
+		if (err == nil) != tt.valid || (err != nil && !errors.Is(err, ErrInvalidOptions)) {
+			t.Errorf("%+v: got %v, want valid = %v", tt.policy, err, tt.valid)
+		}
+	}
+}
+
+func TestFailedWALWriteFailsLaterWrites(t *testing.T) {
+	discardLogs(t)
+	mem := vfs.NewMem()
+	// the WAL sync of the second write fails, after its record was written
+	fs := vfs.NewFaultFS(mem, vfs.Injection{Op: vfs.OpSync, Suffix: ".log", N: 2, Fault: vfs.FaultError})
+	d, err := Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Set([]byte("key"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Set([]byte("key"), []byte("v2")); !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("second write: got %v, want ErrInjected", err)
+	}
+	// no write may be acknowledged after the failed one, as it could reuse its sequence numbers
+	for i := 3; i < 10; i++ {
+		if err = d.Set([]byte("key"), []byte(fmt.Sprintf("v%d", i))); !errors.Is(err, vfs.ErrInjected) {
+			t.Fatalf("write after the failed one: got %v, want ErrInjected", err)
+		}
+	}
+	if v, err := d.Get([]byte("key")); err != nil || string(v) != "v1" {
+		t.Fatalf("get before reopen: got %q (%v), want %q", v, err, "v1")
+	}
+	d.Close()
+
+	if d, err = Open("db", &Options{FS: mem}); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	// the failed write may have made it to the WAL, but nothing written after it
+	if v, err := d.Get([]byte("key")); err != nil || (string(v) != "v1" && string(v) != "v2") {
+		t.Fatalf("get after reopen: got %q (%v), want %q or %q", v, err, "v1", "v2")
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"bytes"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/sstable"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/storage"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+// tableBuilder writes a new *.sst file while keeping track of the range of user keys it covers. The file is written
+// under a temporary name and only renamed into place once complete, so a crash never leaves a partial table behind.
+type tableBuilder struct {
+	w           *sstable.Writer
+	file        vfs.File // nil once closed by finish
+	meta        *storage.FileMetadata
+	dataStorage *storage.Provider
+	smallest    []byte
+	largest     []byte
+}
+
+func (d *DB) newTableBuilder() (*tableBuilder, error) {
+	meta := d.dataStorage.PrepareNewSSTFile()
+	f, err := d.dataStorage.OpenTempFileForWriting(meta)
+	if err != nil {
+		return nil, err
+	}
+	return &tableBuilder{w: sstable.NewWriter(f, d.opts.writerOptions()), file: f, meta: meta, dataStorage: d.dataStorage}, nil
+}
+
+func (b *tableBuilder) add(key, val []byte) error {
+	userKey := encoder.UserKey(key)
+	if b.smallest == nil {
+		b.smallest = bytes.Clone(userKey)
+	}
+	b.largest = append(b.largest[:0], userKey...)
+	return b.w.Add(key, val)
+}
+
+func (b *tableBuilder) size() int {
+	return b.w.Size()
+}
+
+// finish completes the *.sst file, syncs it to stable storage, renames it into place and records its size and key
+// range.
+func (b *tableBuilder) finish() error {
+	if err := b.w.Finish(); err != nil {
+		return err
+	}
+	size := b.w.Size()
+	if err := b.w.Close(); err != nil {
+		return err
+	}
+	b.file = nil
+	if err := b.dataStorage.CommitTempFile(b.meta); err != nil {
+		return err
+	}
+	b.meta.Describe(int64(size), b.smallest, b.largest)
+	return nil
+}
+
+// abort gives up on a table that could not be written, closing and deleting its temporary file.
+func (b *tableBuilder) abort() error {
+	if b.file != nil {
+		// the write already failed, so there is nothing to preserve by reporting a failure to close
+		b.file.Close()
+		b.file = nil
+	}
+	return b.dataStorage.RemoveTempFile(b.meta)
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"container/list"
+	"log"
+	"sync"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/sstable"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/storage"
+)
+
+// tableCache keeps a bounded number of *.sst files open, so that lookups don't pay for opening the file and loading
+// its filter and index blocks every time. It is safe for concurrent use.
+type tableCache struct {
+	dataStorage *storage.Provider
+	blockCache  *sstable.BlockCache
+	cmp         comparer.Comparer
+	capacity    int // maximum number of open tables
+
+	mu     sync.Mutex
+	lru    list.List // most recently used tables at the front
+	tables map[int]*list.Element
+	hits   uint64
+	misses uint64
+	closed bool // set by close, after which no table is opened anymore
+}
+
+// cachedTable is an open table. It stays open until it has been evicted from the cache and released by every user.
+type cachedTable struct {
+	fileNum int
+	r       *sstable.Reader
+	refs    int // one reference is held by the cache itself for as long as the table is cached
+}
+
+func newTableCache(dataStorage *storage.Provider, blockCache *sstable.BlockCache, cmp comparer.Comparer, capacity int) *tableCache {
+	return &tableCache{
+		dataStorage: dataStorage,
+		blockCache:  blockCache,
+		cmp:         cmp,
+		capacity:    capacity,
+		tables:      make(map[int]*list.Element),
+	}
+}
+
+// find returns the open table described by "meta", opening it if needed. The table must be released afterward.
+// ErrClosed is returned once the cache is closed, so that lookups racing with Close don't leak open tables.
+func (c *tableCache) find(meta *storage.FileMetadata) (*cachedTable, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if e, ok := c.tables[meta.FileNum()]; ok {
+		c.hits++
+		c.lru.MoveToFront(e)
+		t := e.Value.(*cachedTable)
+		t.refs++
+		c.mu.Unlock()
+		return t, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// open the table without holding the lock, so that lookups in other tables aren't blocked meanwhile
+	f, err := c.dataStorage.OpenFileForReading(meta)
+	if err != nil {
+		return nil, err
+	}
+	r, err := sstable.NewReader(f, sstable.ReaderOptions{Cache: c.blockCache, FileNum: meta.FileNum(), Comparer: c.cmp})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		r.Close()
+		return nil, ErrClosed
+	}
+	if e, ok := c.tables[meta.FileNum()]; ok {
+		// another goroutine opened the same table in the meantime
+		r.Close()
+		t := e.Value.(*cachedTable)
+		t.refs++
+		return t, nil
+	}
+	t := &cachedTable{fileNum: meta.FileNum(), r: r, refs: 2}
+	c.tables[t.fileNum] = c.lru.PushFront(t)
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+	return t, nil
+}
+
+func (c *tableCache) release(t *cachedTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unref(t)
+}
+
+// evict drops the table with the given file number from the cache, e.g., because the file is about to be deleted.
+func (c *tableCache) evict(fileNum int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.tables[fileNum]; ok {
+		c.remove(e)
+	}
+}
+
+// close drops every table from the cache. Tables still used by iterators are closed once those are released.
+func (c *tableCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *tableCache) remove(e *list.Element) {
+	t := c.lru.Remove(e).(*cachedTable)
+	delete(c.tables, t.fileNum)
+	c.unref(t)
+}
+
+func (c *tableCache) unref(t *cachedTable) {
+	t.refs--
+	if t.refs == 0 {
+		if err := t.r.Close(); err != nil {
+			log.Printf("Failed to close sstable %d: %v", t.fileNum, err)
+		}
+	}
+}
+
+// get returns the most recent version of "key" whose sequence number does not exceed "seqNum" from the table described
+// by "meta".
+func (c *tableCache) get(meta *storage.FileMetadata, key []byte, seqNum uint64) (*encoder.EncodedValue, error) {
+	t, err := c.find(meta)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(t)
+	return t.r.Get(key, seqNum)
+}
+
+// newIterator returns an iterator over the table described by "meta". Closing the iterator releases the table.
+func (c *tableCache) newIterator(meta *storage.FileMetadata) (*tableIterator, error) {
+	t, err := c.find(meta)
+	if err != nil {
+		return nil, err
+	}
+	return &tableIterator{Iterator: t.r.NewIterator(), cache: c, table: t}, nil
+}
+
+func (c *tableCache) stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// tableIterator holds on to the table it iterates over until it is closed.
+type tableIterator struct {
+	*sstable.Iterator
+	cache *tableCache
+	table *cachedTable
+}
+
+func (i *tableIterator) Close() error {
+	err := i.Iterator.Close()
+	if i.table != nil {
+		i.cache.release(i.table)
+		i.table = nil
+	}
+	return err
+}
+
+// CacheStats reports how many lookups were served by the table and block caches.
+type CacheStats struct {
+	TableHits   uint64
+	TableMisses uint64
+	BlockHits   uint64
+	BlockMisses uint64
+}
+
+// CacheStats returns the hit and miss counters of the table and block caches.
+func (d *DB) CacheStats() CacheStats {
+	var s CacheStats
+	s.TableHits, s.TableMisses = d.tableCache.stats()
+	s.BlockHits, s.BlockMisses = d.blockCache.Stats()
+	return s
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"cmp"
+	"log"
+	"slices"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/storage"
+)
+
+const numLevels = 7
+
+// version describes the set of sstables making up the LSM tree, organized into levels. Level 0 holds the output of
+// memtable flushes sorted from oldest to newest, and the key ranges of its tables may overlap. Every other level holds
+// tables with non-overlapping key ranges sorted by their smallest key. A version is never modified once installed.
+type version struct {
+	levels [numLevels][]*storage.FileMetadata
+	refs   int               // number of readers using the version, protected by DB.mu
+	cmp    comparer.Comparer // orders the key ranges of the tables
+}
+
+// apply produces a new version by applying the changes recorded in "edit".
+func (v *version) apply(edit *storage.VersionEdit) *version {
+	nv := &version{cmp: v.cmp}
+	for l := 0; l < numLevels; l++ {
+		for _, f := range v.levels[l] {
+			deleted := slices.ContainsFunc(edit.Deleted, func(d storage.DeletedFileEntry) bool {
+				return d.Level == l && d.FileNum == f.FileNum()
+			})
+			if !deleted {
+				nv.levels[l] = append(nv.levels[l], f)
+			}
+		}
+	}
+	for _, a := range edit.Added {
+		nv.levels[a.Level] = append(nv.levels[a.Level], a.Meta)
+	}
+	slices.SortFunc(nv.levels[0], func(a, b *storage.FileMetadata) int {
+		return cmp.Compare(a.FileNum(), b.FileNum())
+	})
+	for l := 1; l < numLevels; l++ {
+		slices.SortFunc(nv.levels[l], func(a, b *storage.FileMetadata) int {
+			return v.cmp.Compare(a.Smallest(), b.Smallest())
+		})
+	}
+	return nv
+}
+
+// snapshot returns a version edit that recreates "v" when applied to an empty version.
+func (v *version) snapshot() *storage.VersionEdit {
+	edit := &storage.VersionEdit{}
+	for l := 0; l < numLevels; l++ {
+		for _, f := range v.levels[l] {
+			edit.Added = append(edit.Added, storage.NewFileEntry{Level: l, Meta: f})
+		}
+	}
+	return edit
+}
+
+// contains reports whether the table with the given file number is part of "v".
+func (v *version) contains(fileNum int) bool {
+	for l := 0; l < numLevels; l++ {
+		for _, f := range v.levels[l] {
+			if f.FileNum() == fileNum {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// overlaps returns the tables at "level" whose key ranges intersect [smallest, largest].
+func (v *version) overlaps(level int, smallest, largest []byte) []*storage.FileMetadata {
+	var found []*storage.FileMetadata
+	for _, f := range v.levels[level] {
+		if v.cmp.Compare(f.Largest(), smallest) < 0 || v.cmp.Compare(f.Smallest(), largest) > 0 {
+			continue
+		}
+		found = append(found, f)
+	}
+	return found
+}
+
+// levelSize returns the total size (in bytes) of the tables at "level".
+func (v *version) levelSize(level int) int64 {
+	var size int64
+	for _, f := range v.levels[level] {
+		size += f.Size()
+	}
+	return size
+}
+
+// tables returns all tables ordered from the most to the least recent data.
+func (v *version) tables() []*storage.FileMetadata {
+	var all []*storage.FileMetadata
+	for j := len(v.levels[0]) - 1; j >= 0; j-- {
+		all = append(all, v.levels[0][j])
+	}
+	for l := 1; l < numLevels; l++ {
+		all = append(all, v.levels[l]...)
+	}
+	return all
+}
+
+// tablesForKey returns the tables whose key ranges contain "key", ordered from the most to the least recent data.
+func (v *version) tablesForKey(key []byte) []*storage.FileMetadata {
+	var found []*storage.FileMetadata
+	for j := len(v.levels[0]) - 1; j >= 0; j-- {
+		f := v.levels[0][j]
+		if v.cmp.Compare(key, f.Smallest()) >= 0 && v.cmp.Compare(key, f.Largest()) <= 0 {
+			found = append(found, f)
+		}
+	}
+	// the tables of every other level are sorted and don't overlap, so at most one of them can hold the key
+	for l := 1; l < numLevels; l++ {
+		files := v.levels[l]
+		j, _ := slices.BinarySearchFunc(files, key, func(f *storage.FileMetadata, key []byte) int {
+			return v.cmp.Compare(f.Largest(), key)
+		})
+		if j < len(files) && v.cmp.Compare(key, files[j].Smallest()) >= 0 {
+			found = append(found, files[j])
+		}
+	}
+	return found
+}
+
+// tablesInRange returns the tables whose key ranges intersect [lower, upper), ordered from the most to the least
+// recent data. A nil bound leaves that side of the range open.
+func (v *version) tablesInRange(lower, upper []byte) []*storage.FileMetadata {
+	return slices.DeleteFunc(v.tables(), func(f *storage.FileMetadata) bool {
+		return (lower != nil && v.cmp.Compare(f.Largest(), lower) < 0) ||
+			(upper != nil && v.cmp.Compare(f.Smallest(), upper) >= 0)
+	})
+}
+
+// refVersion pins the current version, so that its tables are not deleted by compactions until unrefVersion is called.
+// It is called with d.mu held.
+func (d *DB) refVersion() *version {
+	d.current.refs++
+	return d.current
+}
+
+func (d *DB) unrefVersion(v *version) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v.refs--
+	if v.refs == 0 && v != d.current {
+		d.pinned = slices.DeleteFunc(d.pinned, func(p *version) bool { return p == v })
+		// a read still in flight when Close returned must not touch the data directory, whose lock is released by
+		// then; its obsolete tables are deleted the next time the database is opened
+		if !d.shutDown {
+			d.deleteObsoleteTables()
+		}
+	}
+}
+
+// installVersion makes "v" the current version. The previous one is kept around for as long as readers use it.
+func (d *DB) installVersion(v *version) {
+	if d.current.refs > 0 {
+		d.pinned = append(d.pinned, d.current)
+	}
+	d.current = v
+}
+
+// deleteObsoleteTables removes the tables dropped by compactions that are no longer part of any version in use.
+// A table that cannot be deleted is left behind, to be cleaned up the next time the database is opened.
+func (d *DB) deleteObsoleteTables() {
+	d.zombies = slices.DeleteFunc(d.zombies, func(f *storage.FileMetadata) bool {
+		if d.current.contains(f.FileNum()) {
+			return false
+		}
+		for _, v := range d.pinned {
+			if v.contains(f.FileNum()) {
+				return false
+			}
+		}
+		d.tableCache.evict(f.FileNum())
+		if err := d.dataStorage.DeleteFile(f); err != nil {
+			log.Printf("Failed to delete sstable %d: %v", f.FileNum(), err)
+		}
+		return true
+	})
+}
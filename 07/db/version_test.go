@@ -0,0 +1,102 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/comparer"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/storage"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+)
+
+// newTestVersion builds a version from the key ranges of its tables, given as "smallest-largest" per level. The
+// tables are numbered in the order they are listed, starting at 1, so that later level 0 tables hold newer data.
+func newTestVersion(t *testing.T, levels [][]string) *version {
+	p, err := storage.NewProvider(vfs.NewMem(), "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := &storage.VersionEdit{}
+	for level, ranges := range levels {
+		for _, r := range ranges {
+			smallest, largest, _ := strings.Cut(r, "-")
+			meta := p.PrepareNewSSTFile()
+			meta.Describe(1, []byte(smallest), []byte(largest))
+			edit.Added = append(edit.Added, storage.NewFileEntry{Level: level, Meta: meta})
+		}
+	}
+	return (&version{cmp: comparer.Default}).apply(edit)
+}
+
+func fileNums(files []*storage.FileMetadata) []int {
+	nums := []int{}
+	for _, f := range files {
+		nums = append(nums, f.FileNum())
+	}
+	return nums
+}
+
+// testVersionLevels has overlapping tables in level 0 and gaps between the tables of level 1.
+var testVersionLevels = [][]string{
+	{"a-m", "f-z", "k-p"},        // tables 1 to 3
+	{"a-c", "e-g", "k-n", "q-t"}, // tables 4 to 7
+	{"a-h", "i-z"},               // tables 8 and 9
+}
+
+func TestTablesForKey(t *testing.T) {
+	v := newTestVersion(t, testVersionLevels)
+	tests := []struct {
+		key  string
+		want []int
+	}{
+		{"0", []int{}},
+		{"a", []int{1, 4, 8}},
+		{"d", []int{1, 8}},          // between two tables of level 1
+		{"g", []int{2, 1, 5, 8}},    // largest key of a level 1 table
+		{"k", []int{3, 2, 1, 6, 9}}, // smallest key of a level 1 table
+		{"l", []int{3, 2, 1, 6, 9}}, // within every level 0 table
+		{"p", []int{3, 2, 9}},       // between two tables of level 1
+		{"u", []int{2, 9}},          // after the last table of level 1
+		{"zz", []int{}},             // after all tables
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := fileNums(v.tablesForKey([]byte(tt.key))); !slices.Equal(got, tt.want) {
+				t.Fatalf("got tables %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTablesInRange(t *testing.T) {
+	v := newTestVersion(t, testVersionLevels)
+	tests := []struct {
+		name         string
+		lower, upper string
+		want         []int
+	}{
+		{"unbounded", "", "", []int{3, 2, 1, 4, 5, 6, 7, 8, 9}},
+		{"upper bound excluded", "h", "k", []int{2, 1, 8, 9}},
+		{"lower bound included", "g", "h", []int{2, 1, 5, 8}},
+		{"gap in level 1", "d", "e", []int{1, 8}},
+		{"lower bound only", "u", "", []int{2, 9}},
+		{"upper bound only", "", "b", []int{1, 4, 8}},
+		{"after all tables", "zz", "", []int{}},
+		{"before all tables", "", "a", []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lower, upper []byte
+			if tt.lower != "" {
+				lower = []byte(tt.lower)
+			}
+			if tt.upper != "" {
+				upper = []byte(tt.upper)
+			}
+			if got := fileNums(v.tablesInRange(lower, upper)); !slices.Equal(got, tt.want) {
+				t.Fatalf("got tables %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
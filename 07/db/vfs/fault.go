@@ -0,0 +1,399 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrInjected is returned by the operations a FaultFS fails on purpose.
+var ErrInjected = errors.New("injected fault")
+
+// Op identifies a kind of file system operation a fault can be injected into.
+type Op int
+
+const (
+	OpCreate Op = iota // Create and CreateExclusive
+	OpOpen
+	OpRemove
+	OpRename
+	OpList
+	OpMkdir
+	OpLock
+	OpSyncDir
+	OpRead // Read and ReadAt
+	OpWrite
+	OpSync
+	OpStat
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpCreate:
+		return "create"
+	case OpOpen:
+		return "open"
+	case OpRemove:
+		return "remove"
+	case OpRename:
+		return "rename"
+	case OpList:
+		return "list"
+	case OpMkdir:
+		return "mkdir"
+	case OpLock:
+		return "lock"
+	case OpSyncDir:
+		return "syncdir"
+	case OpRead:
+		return "read"
+	case OpWrite:
+		return "write"
+	case OpSync:
+		return "sync"
+	case OpStat:
+		return "stat"
+	}
+	return fmt.Sprintf("op(%d)", int(op))
+}
+
+// Fault is what happens to an operation hit by an Injection.
+type Fault int
+
+const (
+	// FaultError fails the operation with ErrInjected before it takes effect.
+	FaultError Fault = iota
+	// FaultShortWrite stores only the first half of the data passed to a write, which then fails with ErrInjected.
+	// For any other operation, it behaves like FaultError.
+	FaultShortWrite
+	// FaultCrash simulates a crash right before the operation, see FaultFS.Crash.
+	FaultCrash
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultError:
+		return "error"
+	case FaultShortWrite:
+		return "short write"
+	case FaultCrash:
+		return "crash"
+	}
+	return fmt.Sprintf("fault(%d)", int(f))
+}
+
+// Injection schedules a fault for the Nth operation (counting from 1) of kind Op on a path ending in Suffix. An empty
+// Suffix matches every path.
+type Injection struct {
+	Op     Op
+	Suffix string
+	N      int
+	Fault  Fault
+}
+
+func (i Injection) String() string {
+	return fmt.Sprintf("%s on %s #%d of %q", i.Fault, i.Op, i.N, i.Suffix)
+}
+
+// FaultFS wraps another FS and injects faults into its operations according to a schedule. It also keeps track of
+// the data written through it that is not durable yet, so that a crash can be simulated by dropping that data from the
+// wrapped FS, which should therefore treat every operation as durable (like MemFS does).
+type FaultFS struct {
+	fs       FS
+	mu       sync.Mutex
+	schedule []Injection
+	seen     []int // number of operations matched by each injection so far
+	crashed  bool
+	files    map[string]*faultState
+}
+
+// faultState tracks a file written through a FaultFS.
+type faultState struct {
+	size    int64 // number of bytes written
+	synced  int64 // number of bytes covered by the last Sync
+	durable bool  // whether the name of the file was covered by a SyncDir
+}
+
+// NewFaultFS wraps "fs", injecting the faults of "schedule" as the matching operations come along.
+func NewFaultFS(fs FS, schedule ...Injection) *FaultFS {
+	return &FaultFS{fs: fs, schedule: schedule, seen: make([]int, len(schedule)), files: make(map[string]*faultState)}
+}
+
+// Crash simulates a crash of the machine: every file created through the FaultFS loses the data written after its
+// last Sync, and files whose name was not covered by a SyncDir disappear altogether. A file renamed to a new name
+// disappears as well unless a SyncDir followed, while removals and renames replacing another file are treated as
+// durable right away. Every subsequent operation fails with ErrInjected.
+func (f *FaultFS) Crash() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashLocked()
+}
+
+// Crashed reports whether the FaultFS has crashed.
+func (f *FaultFS) Crashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashed
+}
+
+func (f *FaultFS) crashLocked() error {
+	if f.crashed {
+		return nil
+	}
+	f.crashed = true
+	var errs []error
+	for name, st := range f.files {
+		if !st.durable {
+			errs = append(errs, f.fs.Remove(name))
+		} else if st.synced < st.size {
+			errs = append(errs, truncate(f.fs, name, st.synced))
+		}
+	}
+	f.files = nil
+	return errors.Join(errs...)
+}
+
+func exists(fsys FS, name string) bool {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// truncate cuts file "name" of "fsys" down to "size" bytes by rewriting it.
+func truncate(fsys FS, name string, size int64) error {
+	buf := make([]byte, size)
+	r, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(r, buf)
+	if err = errors.Join(err, r.Close()); err != nil {
+		return err
+	}
+	w, err := fsys.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return errors.Join(err, w.Sync(), w.Close())
+}
+
+// inject counts the operation against the schedule and returns the fault to inject into it, if any. A nil error means
+// the operation may proceed. A crash is carried out right away. Operations that modify the wrapped FS hold f.mu from
+// the call to inject until they complete, so that none of them can slip in after a crash.
+func (f *FaultFS) inject(op Op, name string) (Fault, error) {
+	if f.crashed {
+		return FaultCrash, fmt.Errorf("%s %s: %w (crashed)", op, name, ErrInjected)
+	}
+	for i, inj := range f.schedule {
+		if inj.Op != op || !strings.HasSuffix(name, inj.Suffix) {
+			continue
+		}
+		f.seen[i]++
+		if f.seen[i] != inj.N {
+			continue
+		}
+		err := fmt.Errorf("%s %s: %w (%s)", op, name, ErrInjected, inj)
+		if inj.Fault == FaultCrash {
+			err = errors.Join(err, f.crashLocked())
+		}
+		return inj.Fault, err
+	}
+	return 0, nil
+}
+
+// check runs inject for an operation that leaves the wrapped FS unchanged.
+func (f *FaultFS) check(op Op, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.inject(op, name)
+	return err
+}
+
+func (f *FaultFS) Create(name string) (File, error) {
+	return f.create(name, f.fs.Create)
+}
+
+func (f *FaultFS) CreateExclusive(name string) (File, error) {
+	return f.create(name, f.fs.CreateExclusive)
+}
+
+// create runs Create or CreateExclusive (as "create") of the wrapped FS, both of which count as OpCreate.
+func (f *FaultFS) create(name string, create func(string) (File, error)) (File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.inject(OpCreate, name); err != nil {
+		return nil, err
+	}
+	file, err := create(name)
+	if err != nil {
+		return nil, err
+	}
+	name = filepath.Clean(name)
+	f.files[name] = &faultState{}
+	return &faultFile{fs: f, name: name, file: file}, nil
+}
+
+func (f *FaultFS) Open(name string) (File, error) {
+	if err := f.check(OpOpen, name); err != nil {
+		return nil, err
+	}
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: f, name: filepath.Clean(name), file: file}, nil
+}
+
+func (f *FaultFS) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.inject(OpRemove, name); err != nil {
+		return err
+	}
+	if err := f.fs.Remove(name); err != nil {
+		return err
+	}
+	delete(f.files, filepath.Clean(name))
+	return nil
+}
+
+func (f *FaultFS) Rename(oldname, newname string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.inject(OpRename, newname); err != nil {
+		return err
+	}
+	replaced := exists(f.fs, newname)
+	if err := f.fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	if st, ok := f.files[oldname]; ok {
+		delete(f.files, oldname)
+		// undoing the replacement of a file would require its previous contents, so such renames are kept
+		st.durable = st.durable || replaced
+		f.files[newname] = st
+	} else {
+		delete(f.files, newname)
+	}
+	return nil
+}
+
+func (f *FaultFS) List(dir string) ([]string, error) {
+	if err := f.check(OpList, dir); err != nil {
+		return nil, err
+	}
+	return f.fs.List(dir)
+}
+
+func (f *FaultFS) MkdirAll(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.inject(OpMkdir, dir); err != nil {
+		return err
+	}
+	return f.fs.MkdirAll(dir)
+}
+
+func (f *FaultFS) Lock(name string) (io.Closer, error) {
+	if err := f.check(OpLock, name); err != nil {
+		return nil, err
+	}
+	// releasing the lock is left to the wrapped FS, so that it succeeds even after a crash
+	return f.fs.Lock(name)
+}
+
+func (f *FaultFS) SyncDir(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.inject(OpSyncDir, dir); err != nil {
+		return err
+	}
+	if err := f.fs.SyncDir(dir); err != nil {
+		return err
+	}
+	dir = filepath.Clean(dir)
+	for name, st := range f.files {
+		if filepath.Dir(name) == dir {
+			st.durable = true
+		}
+	}
+	return nil
+}
+
+// faultFile is a file opened through a FaultFS.
+type faultFile struct {
+	fs   *FaultFS
+	name string
+	file File
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if err := f.fs.check(OpRead, f.name); err != nil {
+		return 0, err
+	}
+	return f.file.Read(p)
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.fs.check(OpRead, f.name); err != nil {
+		return 0, err
+	}
+	return f.file.ReadAt(p, off)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	fault, err := f.fs.inject(OpWrite, f.name)
+	if err != nil && fault != FaultShortWrite {
+		return 0, err
+	}
+	data := p
+	if err != nil {
+		data = p[:len(p)/2]
+	}
+	n, werr := f.file.Write(data)
+	if st, ok := f.fs.files[f.name]; ok {
+		st.size += int64(n)
+	}
+	if werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+func (f *faultFile) Close() error {
+	// closing never fails on purpose, so that file handles are not leaked by tests
+	return f.file.Close()
+}
+
+func (f *faultFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if _, err := f.fs.inject(OpSync, f.name); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	if st, ok := f.fs.files[f.name]; ok {
+		st.synced = st.size
+	}
+	return nil
+}
+
+func (f *faultFile) Stat() (fs.FileInfo, error) {
+	if err := f.fs.check(OpStat, f.name); err != nil {
+		return nil, err
+	}
+	return f.file.Stat()
+}
@@ -0,0 +1,158 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestFaultFSCrash(t *testing.T) {
+	tests := []struct {
+		name string
+		// run works on a directory "db" holding the durable file "db/old" with contents "old"
+		run  func(t *testing.T, f *FaultFS)
+		want map[string]string // files of "db" after the crash, with their contents
+	}{
+		{
+			name: "unsynced bytes dropped",
+			run: func(t *testing.T, f *FaultFS) {
+				w, err := f.CreateExclusive("db/new")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = f.SyncDir("db"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err = w.Write([]byte("abc")); err != nil {
+					t.Fatal(err)
+				}
+				if err = w.Sync(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err = w.Write([]byte("def")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{"old": "old", "new": "abc"},
+		},
+		{
+			name: "file not covered by SyncDir lost",
+			run: func(t *testing.T, f *FaultFS) {
+				writeFile(t, f, "db/new", "abc")
+			},
+			want: map[string]string{"old": "old"},
+		},
+		{
+			name: "removal durable right away",
+			run: func(t *testing.T, f *FaultFS) {
+				if err := f.Remove("db/old"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "rename replacing a file durable right away",
+			run: func(t *testing.T, f *FaultFS) {
+				writeFile(t, f, "db/old.tmp", "new")
+				if err := f.Rename("db/old.tmp", "db/old"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{"old": "new"},
+		},
+		{
+			name: "rename to a new name lost without SyncDir",
+			run: func(t *testing.T, f *FaultFS) {
+				writeFile(t, f, "db/new.tmp", "new")
+				if err := f.Rename("db/new.tmp", "db/new"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{"old": "old"},
+		},
+		{
+			name: "rename to a new name kept after SyncDir",
+			run: func(t *testing.T, f *FaultFS) {
+				writeFile(t, f, "db/new.tmp", "new")
+				if err := f.Rename("db/new.tmp", "db/new"); err != nil {
+					t.Fatal(err)
+				}
+				if err := f.SyncDir("db"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{"old": "old", "new": "new"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMem()
+			if err := m.MkdirAll("db"); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, m, "db/old", "old")
+			f := NewFaultFS(m)
+			tt.run(t, f)
+			if err := f.Crash(); err != nil {
+				t.Fatal(err)
+			}
+
+			names, err := m.List("db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, name := range names {
+				got[name] = readFile(t, m, "db/"+name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got files %v, want %v", got, tt.want)
+			}
+			for name, data := range tt.want {
+				if got[name] != data {
+					t.Fatalf("got files %v, want %v", got, tt.want)
+				}
+			}
+			// the crashed file system rejects every operation
+			if _, err = f.Open("db/old"); !errors.Is(err, ErrInjected) {
+				t.Fatalf("Open after crash: got %v, want ErrInjected", err)
+			}
+		})
+	}
+}
+
+func TestFaultFSInjections(t *testing.T) {
+	m := NewMem()
+	f := NewFaultFS(m,
+		Injection{Op: OpWrite, Suffix: ".log", N: 2, Fault: FaultShortWrite},
+		Injection{Op: OpCreate, Suffix: ".sst", N: 1, Fault: FaultError},
+	)
+	w, err := f.Create("000001.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("abcd")); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	// only half of the second write makes it to the file
+	if n, err := w.Write([]byte("efgh")); n != 2 || !errors.Is(err, ErrInjected) {
+		t.Fatalf("second write: got %d, %v, want 2, ErrInjected", n, err)
+	}
+	w.Close()
+	if got := readFile(t, m, "000001.log"); got != "abcdef" {
+		t.Fatalf("got %q, want %q", got, "abcdef")
+	}
+
+	// the failed operation has no effect, and the next one of its kind proceeds
+	if _, err = f.CreateExclusive("000002.sst"); !errors.Is(err, ErrInjected) {
+		t.Fatalf("first create: got %v, want ErrInjected", err)
+	}
+	if _, err = m.Open("000002.sst"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("file created despite the injected error: %v", err)
+	}
+	if w, err = f.CreateExclusive("000002.sst"); err != nil {
+		t.Fatalf("second create: %v", err)
+	}
+	w.Close()
+}
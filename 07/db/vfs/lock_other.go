@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package vfs
+
+import "os"
+
+// lockFile is a no-op on platforms without flock, where the data directory is not protected against concurrent use.
+func lockFile(f *os.File) error {
+	return nil
+}
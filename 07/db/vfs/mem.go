@@ -0,0 +1,280 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemFS is a file system held entirely in memory. Every operation is durable as soon as it completes, so Sync and
+// SyncDir have no effect. Wrap it in a FaultFS to simulate a crash that loses unsynced data.
+type MemFS struct {
+	mu     sync.Mutex
+	dirs   map[string]bool
+	files  map[string]*memNode
+	locked map[string]bool
+}
+
+// memNode holds the contents of a file. It outlives its name when the file is removed while still open.
+type memNode struct {
+	mu      sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+func NewMem() *MemFS {
+	return &MemFS{
+		dirs:   map[string]bool{".": true, string(filepath.Separator): true},
+		files:  make(map[string]*memNode),
+		locked: make(map[string]bool),
+	}
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	return m.create(name, false)
+}
+
+func (m *MemFS) CreateExclusive(name string) (File, error) {
+	return m.create(name, true)
+}
+
+func (m *MemFS) create(name string, exclusive bool) (File, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirs[filepath.Dir(name)] {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrNotExist}
+	}
+	if m.dirs[name] {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: errIsDir}
+	}
+	if _, ok := m.files[name]; ok && exclusive {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	n := &memNode{modTime: time.Now()}
+	m.files[name] = n
+	return &memFile{name: name, node: n, writable: true}, nil
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFile{name: name, node: n}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.files[oldname]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if !m.dirs[filepath.Dir(newname)] {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldname)
+	m.files[newname] = n
+	return nil
+}
+
+func (m *MemFS) List(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirs[dir] {
+		return nil, &fs.PathError{Op: "list", Path: dir, Err: fs.ErrNotExist}
+	}
+	var names []string
+	for name := range m.files {
+		if filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	for name := range m.dirs {
+		if name != dir && filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (m *MemFS) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ; !m.dirs[dir]; dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: errNotDir}
+		}
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+func (m *MemFS) Lock(name string) (io.Closer, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locked[name] {
+		return nil, ErrLocked
+	}
+	if _, ok := m.files[name]; !ok {
+		if !m.dirs[filepath.Dir(name)] {
+			return nil, &fs.PathError{Op: "lock", Path: name, Err: fs.ErrNotExist}
+		}
+		m.files[name] = &memNode{modTime: time.Now()}
+	}
+	m.locked[name] = true
+	return &memLock{fs: m, name: name}, nil
+}
+
+func (m *MemFS) SyncDir(dir string) error {
+	dir = filepath.Clean(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirs[dir] {
+		return &fs.PathError{Op: "sync", Path: dir, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errReadOnly = errors.New("file opened for reading only")
+)
+
+type memLock struct {
+	fs   *MemFS
+	name string
+	once sync.Once
+}
+
+func (l *memLock) Close() error {
+	l.once.Do(func() {
+		l.fs.mu.Lock()
+		delete(l.fs.locked, l.name)
+		l.fs.mu.Unlock()
+	})
+	return nil
+}
+
+// memFile is an open file of a MemFS. Reads and writes start at the current offset, except for ReadAt.
+type memFile struct {
+	name     string
+	node     *memNode
+	offset   int64
+	writable bool
+	closed   bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.node.mu.RLock()
+	defer f.node.mu.RUnlock()
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.node.mu.RLock()
+	defer f.node.mu.RUnlock()
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	}
+	if !f.writable {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: errReadOnly}
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	if f.closed {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: fs.ErrClosed}
+	}
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.node.mu.RLock()
+	defer f.node.mu.RUnlock()
+	return &memFileInfo{name: filepath.Base(f.name), size: int64(len(f.node.data)), modTime: f.node.modTime}, nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return 0644 }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return false }
+func (i *memFileInfo) Sys() any           { return nil }
@@ -0,0 +1,107 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+func writeFile(t *testing.T, fsys FS, name, data string) {
+	t.Helper()
+	f, err := fsys.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = errors.Join(f.Sync(), f.Close()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, fsys FS, name string) string {
+	t.Helper()
+	buf, err := ReadFile(fsys, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestMemFS(t *testing.T) {
+	m := NewMem()
+	if err := m.MkdirAll("db"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, m, "db/a", "hello")
+	if got := readFile(t, m, "db/a"); got != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+
+	// Create truncates, CreateExclusive refuses to touch an existing file
+	writeFile(t, m, "db/a", "hi")
+	if got := readFile(t, m, "db/a"); got != "hi" {
+		t.Fatalf("got %q after Create, want %q", got, "hi")
+	}
+	if _, err := m.CreateExclusive("db/a"); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("CreateExclusive of an existing file: got %v, want fs.ErrExist", err)
+	}
+	f, err := m.CreateExclusive("db/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err = m.Create("missing/c"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Create in a missing directory: got %v, want fs.ErrNotExist", err)
+	}
+
+	// a file stays readable through an open handle after it is replaced
+	r, err := m.Open("db/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, m, "db/c", "new")
+	if err = m.Rename("db/c", "db/a"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if _, err = r.ReadAt(buf, 0); err != nil || string(buf) != "hi" {
+		t.Fatalf("read from replaced file: got %q (%v), want %q", buf, err, "hi")
+	}
+	r.Close()
+	if got := readFile(t, m, "db/a"); got != "new" {
+		t.Fatalf("got %q after Rename, want %q", got, "new")
+	}
+
+	if err = m.Remove("db/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Open("db/b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Open of a removed file: got %v, want fs.ErrNotExist", err)
+	}
+	names, err := m.List("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a"}; !slices.Equal(names, want) {
+		t.Fatalf("List: got %v, want %v", names, want)
+	}
+}
+
+func TestMemFSLock(t *testing.T) {
+	m := NewMem()
+	l, err := m.Lock("LOCK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Lock("LOCK"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock: got %v, want ErrLocked", err)
+	}
+	l.Close()
+	if l, err = m.Lock("LOCK"); err != nil {
+		t.Fatalf("Lock after release: %v", err)
+	}
+	l.Close()
+}
@@ -0,0 +1,118 @@
+// Package vfs abstracts the file system operations the database relies on, so that it can run on the local disk, fully
+// in memory, or on top of a file system that injects faults.
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+var ErrLocked = errors.New("data directory locked by another process")
+
+// File is an open file. Files opened for reading only support the read methods.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Closer
+	// Sync commits the contents of the file to stable storage.
+	Sync() error
+	Stat() (fs.FileInfo, error)
+}
+
+// FS is a file system. Names are paths in the format of the host operating system, as built by filepath.Join.
+// Errors about missing files match fs.ErrNotExist.
+type FS interface {
+	// Create creates the file "name", truncating it if it already exists, and opens it for reading and writing.
+	Create(name string) (File, error)
+	// CreateExclusive is like Create, but fails with an error matching fs.ErrExist if the file already exists.
+	CreateExclusive(name string) (File, error)
+	// Open opens the file "name" for reading.
+	Open(name string) (File, error)
+	Remove(name string) error
+	// Rename moves the file "oldname" to "newname", replacing any file already there.
+	Rename(oldname, newname string) error
+	// List returns the names of the files in directory "dir".
+	List(dir string) ([]string, error)
+	MkdirAll(dir string) error
+	// Lock takes an exclusive lock on the file "name", creating it if needed, and fails with ErrLocked if the lock is
+	// held elsewhere. Closing the returned io.Closer releases the lock.
+	Lock(name string) (io.Closer, error)
+	// SyncDir makes any files created, renamed, or deleted in directory "dir" durable.
+	SyncDir(dir string) error
+}
+
+// Default is the file system of the host operating system.
+var Default FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Create(name string) (File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (osFS) CreateExclusive(name string) (File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (osFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func (osFS) Lock(name string) (io.Closer, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// closing the file releases the lock
+	return f, nil
+}
+
+func (osFS) SyncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	return errors.Join(err, f.Close())
+}
+
+// ReadFile returns the whole contents of the file "name".
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := io.ReadAll(f)
+	return buf, errors.Join(err, f.Close())
+}
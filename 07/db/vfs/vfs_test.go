@@ -0,0 +1,20 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+func TestOSCreateExclusive(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "000001.log")
+	f, err := Default.CreateExclusive(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err = Default.CreateExclusive(name); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("got %v, want fs.ErrExist", err)
+	}
+}
@@ -4,80 +4,151 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-
-	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
 )
 
+var ErrCorrupted = errors.New("wal corrupted")
+
+// CorruptionError describes a chunk that failed validation, e.g., because it was torn by a crash or its checksum
+// doesn't match. It matches ErrCorrupted when used with errors.Is.
+type CorruptionError struct {
+	Offset int64 // offset of the chunk within the WAL file
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("wal corrupted at offset %d: %s", e.Offset, e.Reason)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
 type Reader struct {
 	file     io.Reader
 	blockNum int
 	block    *block
-	encoder  *encoder.Encoder
 	buf      *bytes.Buffer
+	resync   bool // set after a corruption, until the start of the next record is found
 }
 
-func NewReader(logFile io.ReadCloser) *Reader {
+// NewReader prepares "logFile" for reading records. "blockSize" must match the block size the WAL was written with.
+func NewReader(logFile io.ReadCloser, blockSize int) *Reader {
 	return &Reader{
 		file:     logFile,
 		blockNum: -1,
-		block:    &block{},
-		encoder:  encoder.NewEncoder(),
+		block:    newBlock(blockSize),
 		buf:      &bytes.Buffer{},
 	}
 }
 
-func (r *Reader) Next() (key []byte, val *encoder.EncodedValue, err error) {
-	b := r.block
-	// load the very first WAL block into memory
-	if r.blockNum == -1 {
-		if err = r.loadNextBlock(); err != nil {
-			return
-		}
-	}
-	// check if EOF reached (when last block in WAL is not properly sealed)
-	if b.offset >= b.len {
-		err = io.EOF
-		return
-	}
-	// check if last record in block reached (when last block in WAL is properly sealed)
-	if b.len-b.offset <= headerSize {
-		if err = r.loadNextBlock(); err != nil {
-			return
-		}
-	}
+// Next returns the payload of the subsequent record in the WAL, or io.EOF once all records have been read.
+// A *CorruptionError is returned for a record that cannot be recovered. The Reader remains usable afterward and
+// resumes with the first record starting after the corrupted part of the WAL.
+func (r *Reader) Next() (record []byte, err error) {
 	// start with a clean scratch buffer
 	r.buf.Reset()
+	inRecord := false
 	// recover all chunks to form the full payload
 	for {
-		start := b.offset
-		// extract data from chunk header (payload length and chunk type)
-		dataLen := int(binary.LittleEndian.Uint16(b.buf[start : start+2]))
-		chunkType := b.buf[start+2]
+		start, chunkType, payload, err := r.nextChunk()
+		if err != nil {
+			if err == io.EOF && inRecord {
+				r.resync = true
+				return nil, r.corruption(start, "record truncated at end of file")
+			}
+			return nil, err
+		}
+		switch chunkType {
+		case chunkTypeFull, chunkTypeFirst:
+			if inRecord {
+				// re-read this chunk on the next call, as it starts a new record
+				r.block.offset = start
+				r.resync = false
+				return nil, r.corruption(start, "record is missing its last chunk")
+			}
+			r.resync = false
+			inRecord = true
+		case chunkTypeMiddle, chunkTypeLast:
+			if !inRecord {
+				if r.resync {
+					continue // remainder of a record that was corrupted earlier
+				}
+				r.resync = true
+				return nil, r.corruption(start, "chunk does not belong to any record")
+			}
+		}
 		// copy recovered payload to scratch buffer
-		r.buf.Write(b.buf[start+headerSize : start+headerSize+dataLen])
-		// advance the data block offset
-		b.offset += headerSize + dataLen
+		r.buf.Write(payload)
 		// check if there are no chunks left to process for this record
 		if chunkType == chunkTypeFull || chunkType == chunkTypeLast {
 			break
 		}
-		// load next block to retrieve the subsequent chunk
-		if err = r.loadNextBlock(); err != nil {
-			return
-		}
 	}
-	// retrieve scratch buffer contents (i.e., the payload)
-	scratch := r.buf.Bytes()
-	// parse the WAL record
-	keyLen, n := binary.Uvarint(scratch[:])
-	_, m := binary.Uvarint(scratch[n:])
-	key = make([]byte, keyLen)
-	copy(key, scratch[n+m:n+m+int(keyLen)])
-	val = r.encoder.Parse(scratch[n+m+int(keyLen):])
+	// return a copy of the scratch buffer contents (i.e., the payload)
+	record = bytes.Clone(r.buf.Bytes())
 	return
 }
 
+// nextChunk returns the subsequent valid chunk, together with its offset within the current block. The remainder of
+// a block holding a corrupted chunk is skipped.
+func (r *Reader) nextChunk() (start int, chunkType byte, payload []byte, err error) {
+	b := r.block
+	for {
+		// load the very first WAL block into memory, or the next one when the current block has no room left for a chunk
+		if r.blockNum == -1 || (b.len == len(b.buf) && b.len-b.offset <= headerSize) {
+			if err = r.loadNextBlock(); err != nil {
+				return b.offset, 0, nil, err
+			}
+			continue
+		}
+		start = b.offset
+		// check if EOF reached (when last block in WAL is not properly sealed)
+		if start >= b.len {
+			return start, 0, nil, io.EOF
+		}
+		if b.len-start < headerSize {
+			b.offset = b.len
+			r.resync = true
+			return start, 0, nil, r.corruption(start, "chunk header truncated")
+		}
+		// extract data from chunk header (checksum, payload length and chunk type)
+		checksum := binary.LittleEndian.Uint32(b.buf[start:])
+		dataLen := int(binary.LittleEndian.Uint16(b.buf[start+4:]))
+		chunkType = b.buf[start+6]
+		if checksum == 0 && dataLen == 0 && chunkType == 0 {
+			// zero padding fills the rest of a sealed block
+			b.offset = b.len
+			continue
+		}
+		end := start + headerSize + dataLen
+		if end > b.len {
+			b.offset = b.len
+			r.resync = true
+			return start, 0, nil, r.corruption(start, "chunk payload truncated")
+		}
+		payload = b.buf[start+headerSize : end]
+		if chunkType < chunkTypeFull || chunkType > chunkTypeLast {
+			b.offset = b.len
+			r.resync = true
+			return start, 0, nil, r.corruption(start, fmt.Sprintf("unknown chunk type %d", chunkType))
+		}
+		if chunkChecksum(chunkType, payload) != checksum {
+			b.offset = b.len
+			r.resync = true
+			return start, 0, nil, r.corruption(start, "checksum mismatch")
+		}
+		// advance the data block offset
+		b.offset = end
+		return start, chunkType, payload, nil
+	}
+}
+
+func (r *Reader) corruption(offset int, reason string) error {
+	return &CorruptionError{Offset: int64(r.blockNum)*int64(len(r.block.buf)) + int64(offset), Reason: reason}
+}
+
 func (r *Reader) loadNextBlock() (err error) {
 	b := r.block
 	b.len, err = io.ReadFull(r.file, b.buf[:])
@@ -0,0 +1,142 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkType tells how a chunk relates to the record it belongs to.
+type ChunkType byte
+
+const (
+	ChunkTypeFull   ChunkType = chunkTypeFull   // the whole record
+	ChunkTypeFirst  ChunkType = chunkTypeFirst  // the beginning of a record spanning several blocks
+	ChunkTypeMiddle ChunkType = chunkTypeMiddle // a part of such a record that is neither its beginning nor its end
+	ChunkTypeLast   ChunkType = chunkTypeLast   // the end of such a record
+)
+
+func (t ChunkType) String() string {
+	switch t {
+	case ChunkTypeFull:
+		return "FULL"
+	case ChunkTypeFirst:
+		return "FIRST"
+	case ChunkTypeMiddle:
+		return "MIDDLE"
+	case ChunkTypeLast:
+		return "LAST"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", byte(t))
+}
+
+// SegmentKind classifies the parts of a block reported by a Scanner.
+type SegmentKind int
+
+const (
+	SegmentChunk     SegmentKind = iota // a chunk whose header and checksum are valid
+	SegmentPadding                      // the zeros sealing a block
+	SegmentDamaged                      // the remainder of a block, starting with a malformed chunk
+	SegmentTruncated                    // a chunk cut off by the end of the file
+)
+
+// Segment is a part of a WAL block.
+type Segment struct {
+	Kind    SegmentKind
+	Block   int   // number of the block holding the segment
+	Offset  int64 // offset of the segment within the WAL file
+	Length  int   // length of the segment (in bytes), including the chunk header
+	Type    ChunkType
+	Payload []byte // payload of a chunk, valid until the next call to Next
+	Reason  string // describes what is wrong with a damaged or truncated segment
+}
+
+// Scanner walks a WAL file block by block and reports every chunk, every stretch of padding, and every damaged
+// region as it is laid out on disk. Unlike a Reader, it neither reassembles records nor skips over anything, which
+// makes it suitable for inspecting damaged WAL files.
+type Scanner struct {
+	file     io.Reader
+	blockNum int
+	block    *block
+	eof      bool // set once the final, possibly partial, block was loaded
+}
+
+// NewScanner prepares "logFile" for scanning. "blockSize" must match the block size the WAL was written with.
+func NewScanner(logFile io.Reader, blockSize int) *Scanner {
+	return &Scanner{file: logFile, blockNum: -1, block: newBlock(blockSize)}
+}
+
+// BlockSize returns the size of the blocks the WAL file is made of.
+func (s *Scanner) BlockSize() int {
+	return len(s.block.buf)
+}
+
+// Next returns the subsequent segment of the WAL file, or io.EOF once the whole file has been scanned. Only I/O errors
+// are returned otherwise, as malformed input is reported through the segments.
+func (s *Scanner) Next() (*Segment, error) {
+	b := s.block
+	for s.blockNum == -1 || b.offset >= b.len {
+		if s.eof {
+			return nil, io.EOF
+		}
+		n, err := io.ReadFull(s.file, b.buf)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			s.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+		b.len, b.offset = n, 0
+		s.blockNum++
+	}
+
+	start := b.offset
+	seg := &Segment{Block: s.blockNum, Offset: int64(s.blockNum)*int64(len(b.buf)) + int64(start)}
+	rest := b.buf[start:b.len]
+	partial := b.len < len(b.buf) // only the final block of the file can be partial
+
+	damaged := func(kind SegmentKind, reason string) (*Segment, error) {
+		seg.Kind, seg.Length, seg.Reason = kind, len(rest), reason
+		b.offset = b.len
+		return seg, nil
+	}
+	if len(rest) < headerSize || isZero(rest[:headerSize]) {
+		// the writer seals a block with zeros once there is no room left for another chunk
+		if !isZero(rest) {
+			if partial {
+				return damaged(SegmentTruncated, "chunk header truncated")
+			}
+			return damaged(SegmentDamaged, "non-zero bytes in padding")
+		}
+		return damaged(SegmentPadding, "")
+	}
+
+	checksum := binary.LittleEndian.Uint32(rest)
+	dataLen := int(binary.LittleEndian.Uint16(rest[4:]))
+	seg.Type = ChunkType(rest[6])
+	if headerSize+dataLen > len(rest) {
+		if partial {
+			return damaged(SegmentTruncated, "chunk payload truncated")
+		}
+		return damaged(SegmentDamaged, fmt.Sprintf("chunk payload of %d bytes exceeds block", dataLen))
+	}
+	payload := rest[headerSize : headerSize+dataLen]
+	if seg.Type < ChunkTypeFull || seg.Type > ChunkTypeLast {
+		return damaged(SegmentDamaged, fmt.Sprintf("unknown chunk type %d", seg.Type))
+	}
+	if chunkChecksum(byte(seg.Type), payload) != checksum {
+		return damaged(SegmentDamaged, "checksum mismatch")
+	}
+	seg.Kind, seg.Length, seg.Payload = SegmentChunk, headerSize+dataLen, payload
+	b.offset += seg.Length
+	return seg, nil
+}
+
+func isZero(p []byte) bool {
+	for _, c := range p {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -1,16 +1,30 @@
 package wal
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"io"
+	"sync"
+	"time"
+)
+
+const DefaultBlockSize = 4 << 10 // 4 KiB
+
+// chunk header: CRC32C of the chunk type and payload (4 bytes), payload length (2 bytes), chunk type (1 byte)
+const headerSize = 7
 
-	"github.com/cloudcentricdev/golang-tutorials/07/db/encoder"
+// The block size must leave room for a chunk header and at least a byte of payload, while the length of the payload
+// must fit into the 2 bytes reserved for it in the chunk header.
+const (
+	MinBlockSize = headerSize + 1
+	MaxBlockSize = headerSize + 1<<16 - 1
 )
 
-const blockSize = 4 << 10 // 4 KiB
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
 
-const headerSize = 3
+// ErrClosed is returned when writing to a Writer that was already closed.
+var ErrClosed = errors.New("wal closed")
 
 const (
 	chunkTypeFull   = 1
@@ -20,114 +34,187 @@ const (
 )
 
 type block struct {
-	buf    [blockSize]byte
+	buf    []byte
 	offset int
 	len    int
 }
 
+func newBlock(blockSize int) *block {
+	return &block{buf: make([]byte, blockSize)}
+}
+
 type syncWriteCloser interface {
 	io.WriteCloser
 	Sync() error
 }
 
+// SyncMode selects when the WAL is synced to stable storage.
+type SyncMode int
+
+const (
+	// SyncEveryWrite syncs the WAL before each call to Record returns, so that no acknowledged record is ever lost.
+	SyncEveryWrite SyncMode = iota
+	// SyncPeriodically syncs the WAL once a given number of bytes or a given amount of time has accumulated since the
+	// last sync. Records written in the meantime are lost if the machine crashes.
+	SyncPeriodically
+	// SyncNever leaves syncing the WAL to the operating system.
+	SyncNever
+)
+
+// SyncPolicy determines when the WAL is synced to stable storage. Regardless of the policy, the WAL is always synced
+// when the Writer is closed.
+type SyncPolicy struct {
+	Mode     SyncMode
+	Bytes    int           // with SyncPeriodically, sync once this many bytes are unsynced (0 disables the limit)
+	Interval time.Duration // with SyncPeriodically, sync at most this long after a write (0 disables the limit)
+}
+
+// Writer is safe for use by a single writing goroutine. Periodic syncs may take place concurrently in the background.
 type Writer struct {
-	block   *block
-	file    syncWriteCloser
-	encoder *encoder.Encoder
-	buf     *bytes.Buffer
+	mu       sync.Mutex
+	block    *block
+	file     syncWriteCloser
+	policy   SyncPolicy
+	unsynced int         // bytes written since the last sync
+	timer    *time.Timer // pending periodic sync
+	err      error       // error encountered by a periodic sync, reported by the subsequent call to Record
 }
 
-func NewWriter(logFile syncWriteCloser) *Writer {
+// NewWriter prepares "logFile" for appending records split into blocks of "blockSize" bytes. The same block size must
+// be used for reading the WAL.
+func NewWriter(logFile syncWriteCloser, blockSize int, policy SyncPolicy) *Writer {
 	w := &Writer{
-		block:   &block{},
-		file:    logFile,
-		encoder: encoder.NewEncoder(),
-		buf:     &bytes.Buffer{},
+		block:  newBlock(blockSize),
+		file:   logFile,
+		policy: policy,
 	}
 	return w
 }
 
-func (w *Writer) RecordInsertion(key, val []byte) error {
-	val = w.encoder.Encode(encoder.OpKindSet, val)
-	return w.record(key, val)
-}
-
-func (w *Writer) RecordDeletion(key []byte) error {
-	val := w.encoder.Encode(encoder.OpKindDelete, nil)
-	return w.record(key, val)
-}
+// Record appends "p" to the WAL as a single record, splitting it into chunks that span as many blocks as needed.
+// Whether the record is synced to stable storage before Record returns depends on the SyncPolicy.
+func (w *Writer) Record(p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	scratch := p
 
-func (w *Writer) record(key, val []byte) error {
-	// determine the maximum possible payload length
-	keyLen, valLen := len(key), len(val)
-	maxLen := 2*binary.MaxVarintLen64 + keyLen + valLen
-	// initialize a scratch buffer capable of fitting the entire payload
-	scratch := w.scratchBuf(maxLen)
-	// place the entire payload into the scratch buffer
-	n := binary.PutUvarint(scratch[:], uint64(keyLen))
-	n += binary.PutUvarint(scratch[n:], uint64(valLen))
-	copy(scratch[n:], key)
-	copy(scratch[n+keyLen:], val)
-	// calculate the actual scratch buffer length being used
-	dataLen := n + keyLen + valLen
-	// discard the unused portion
-	scratch = scratch[:dataLen]
-
-	// start splitting the payload into chunks
-	for chunk := 0; len(scratch) > 0; chunk++ {
+	// start splitting the payload into chunks (an empty payload still takes up a single chunk)
+	for chunk := 0; chunk == 0 || len(scratch) > 0; chunk++ {
 		// reference the current data block
 		b := w.block
 		// seal the block if it doesn't have enough room to accommodate this chunk
-		if b.offset+headerSize >= blockSize {
+		if b.offset+headerSize >= len(b.buf) {
 			if err := w.sealBlock(); err != nil {
 				return err
 			}
 		}
 		// fill the data block with as much of the available payload as possible
 		buf := b.buf[b.offset:]
-		dataLen = copy(buf[headerSize:], scratch)
-		// write the payload length to the chunk header
-		binary.LittleEndian.PutUint16(buf, uint16(dataLen))
+		dataLen := copy(buf[headerSize:], scratch)
 		// advance the scratch buffer and data block offsets
 		scratch = scratch[dataLen:]
 		b.offset += dataLen + headerSize
 
-		// determine the chunk type and write it to the chunk header
-		if b.offset < blockSize {
+		// determine the chunk type based on whether any payload is left for subsequent chunks
+		if len(scratch) == 0 {
 			if chunk == 0 {
-				buf[2] = chunkTypeFull
+				buf[6] = chunkTypeFull
 			} else {
-				buf[2] = chunkTypeLast
+				buf[6] = chunkTypeLast
 			}
 		} else {
 			if chunk == 0 {
-				buf[2] = chunkTypeFirst
+				buf[6] = chunkTypeFirst
 			} else {
-				buf[2] = chunkTypeMiddle
+				buf[6] = chunkTypeMiddle
 			}
 		}
+		// write the payload length and the checksum to the chunk header
+		binary.LittleEndian.PutUint16(buf[4:], uint16(dataLen))
+		binary.LittleEndian.PutUint32(buf, chunkChecksum(buf[6], buf[headerSize:headerSize+dataLen]))
 
-		// flush updated data block portion to disk
-		if err := w.writeAndSync(buf[:dataLen+headerSize]); err != nil {
+		// write updated data block portion to disk
+		if err := w.write(buf[:dataLen+headerSize]); err != nil {
 			return err
 		}
 	}
+	return w.maybeSync()
+}
+
+// maybeSync syncs the WAL if the SyncPolicy demands it, or arranges for a periodic sync to take place later.
+func (w *Writer) maybeSync() error {
+	switch w.policy.Mode {
+	case SyncEveryWrite:
+		return w.sync()
+	case SyncPeriodically:
+		if w.policy.Bytes > 0 && w.unsynced >= w.policy.Bytes {
+			return w.sync()
+		}
+		if w.policy.Interval > 0 && w.timer == nil && w.unsynced > 0 {
+			w.timer = time.AfterFunc(w.policy.Interval, w.syncOnTimer)
+		}
+	case SyncNever:
+	}
 	return nil
 }
 
-func (w *Writer) scratchBuf(needed int) []byte {
-	available := w.buf.Available()
-	if needed > available {
-		w.buf.Grow(needed)
+// Sync forces the records written so far to stable storage, regardless of the SyncPolicy.
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.unsynced == 0 {
+		return nil
+	}
+	return w.sync()
+}
+
+func (w *Writer) syncOnTimer() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if w.file == nil || w.unsynced == 0 || w.err != nil {
+		return
+	}
+	w.err = w.sync()
+}
+
+func (w *Writer) sync() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
 	}
-	buf := w.buf.AvailableBuffer()
-	return buf[:needed]
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.unsynced = 0
+	return nil
 }
 
+// Close seals the current block, syncs the WAL, and closes the underlying file.
 func (w *Writer) Close() (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return ErrClosed
+	}
 	if err = w.sealBlock(); err != nil {
 		return err
 	}
+	if err = w.sync(); err != nil {
+		return err
+	}
 	err = w.file.Close()
 	w.file = nil
 	if err != nil {
@@ -136,24 +223,28 @@ func (w *Writer) Close() (err error) {
 	return nil
 }
 
-// sealBlock applies zero padding to the current block and calls writeAndSync to persist it to stable storage
+// chunkChecksum computes the CRC32C of the chunk type followed by the chunk payload.
+func chunkChecksum(chunkType byte, payload []byte) uint32 {
+	crc := crc32.Update(0, crcTable, []byte{chunkType})
+	return crc32.Update(crc, crcTable, payload)
+}
+
+// sealBlock applies zero padding to the current block and calls write to persist it
 func (w *Writer) sealBlock() error {
 	b := w.block
 	clear(b.buf[b.offset:])
-	if err := w.writeAndSync(b.buf[b.offset:]); err != nil {
+	if err := w.write(b.buf[b.offset:]); err != nil {
 		return err
 	}
 	b.offset = 0
 	return nil
 }
 
-// writeAndSync writes to the underlying WAL file and forces a sync of its contents to stable storage
-func (w *Writer) writeAndSync(p []byte) (err error) {
+// write writes to the underlying WAL file, leaving it to maybeSync to force its contents to stable storage
+func (w *Writer) write(p []byte) (err error) {
 	if _, err = w.file.Write(p); err != nil {
 		return err
 	}
-	if err = w.file.Sync(); err != nil {
-		return err
-	}
+	w.unsynced += len(p)
 	return nil
 }
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudcentricdev/golang-tutorials/07/db/vfs"
+	"github.com/cloudcentricdev/golang-tutorials/07/db/wal"
+)
+
+const walTestKeys = 300
+
+// writeWALs fills a database with keys written in ascending order, without flushing any memtable, and returns the
+// names of the non-empty WAL files holding them, oldest first.
+func writeWALs(t *testing.T, fs vfs.FS) []string {
+	d, err := Open("db", &Options{FS: fs, MemtableSizeLimit: 1 << 10, MemtableFlushThreshold: 1 << 20, WALBlockSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < walTestKeys; k++ {
+		if err = d.Set([]byte(fmt.Sprintf("key%03d", k)), []byte("val")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, err := fs.List("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		name = filepath.Join("db", name)
+		if buf, err := vfs.ReadFile(fs, name); err != nil {
+			t.Fatal(err)
+		} else if len(buf) > 0 {
+			logs = append(logs, name)
+		}
+	}
+	if len(logs) < 3 {
+		t.Fatalf("got %d WAL files, want at least 3", len(logs))
+	}
+	return logs
+}
+
+// corruptFile flips a byte of file "name", at offset "off" counted from the end of the last record when negative.
+func corruptFile(t *testing.T, fs vfs.FS, name string, off int) {
+	buf, err := vfs.ReadFile(fs, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off < 0 {
+		off += recordsEnd(buf)
+	}
+	buf[off] ^= 0xff
+	rewriteFile(t, fs, name, buf)
+}
+
+// recordsEnd returns the size of WAL file contents "buf" without the padding that sealed its last block.
+func recordsEnd(buf []byte) int {
+	return len(bytes.TrimRight(buf, "\x00"))
+}
+
+func rewriteFile(t *testing.T, fs vfs.FS, name string, buf []byte) {
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// presentKeys returns which of the keys written by writeWALs the database holds.
+func presentKeys(t *testing.T, d *DB) []bool {
+	present := make([]bool, walTestKeys)
+	for k := range present {
+		_, err := d.Get([]byte(fmt.Sprintf("key%03d", k)))
+		present[k] = err == nil
+	}
+	return present
+}
+
+// prefixLen returns the number of keys present before the first missing one, and whether all keys after it are
+// missing as well.
+func prefixLen(present []bool) (int, bool) {
+	n := 0
+	for n < len(present) && present[n] {
+		n++
+	}
+	for _, ok := range present[n:] {
+		if ok {
+			return n, false
+		}
+	}
+	return n, true
+}
+
+func TestWALRecoveryModes(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name    string
+		mode    WALRecoveryMode
+		damage  func(t *testing.T, fs vfs.FS, logs []string)
+		wantErr error
+		// check receives the keys recovered from the damaged WALs
+		check func(t *testing.T, present []bool)
+	}{
+		{
+			name: "torn tail of last WAL/stop",
+			mode: WALRecoveryStopAtCorruption,
+			damage: func(t *testing.T, fs vfs.FS, logs []string) {
+				last := logs[len(logs)-1]
+				buf, err := vfs.ReadFile(fs, last)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rewriteFile(t, fs, last, buf[:recordsEnd(buf)-3])
+			},
+			check: func(t *testing.T, present []bool) {
+				if n, ok := prefixLen(present); !ok || n != walTestKeys-1 {
+					t.Fatalf("recovered %d keys (prefix %v), want all but the torn one", n, ok)
+				}
+			},
+		},
+		{
+			name: "torn tail of last WAL/fail",
+			mode: WALRecoveryFail,
+			damage: func(t *testing.T, fs vfs.FS, logs []string) {
+				corruptFile(t, fs, logs[len(logs)-1], -1)
+			},
+			wantErr: wal.ErrCorrupted,
+		},
+		{
+			name: "middle WAL/stop",
+			mode: WALRecoveryStopAtCorruption,
+			damage: func(t *testing.T, fs vfs.FS, logs []string) {
+				corruptFile(t, fs, logs[1], 16)
+			},
+			check: func(t *testing.T, present []bool) {
+				// nothing written after the corrupted record may come back, not even from the later WAL files
+				n, ok := prefixLen(present)
+				if !ok {
+					t.Fatalf("key%03d is lost, but keys written after it were recovered", n)
+				}
+				if n == 0 || present[walTestKeys-1] {
+					t.Fatalf("recovered %d keys, want those of the first WAL only", n)
+				}
+			},
+		},
+		{
+			name: "middle WAL/fail",
+			mode: WALRecoveryFail,
+			damage: func(t *testing.T, fs vfs.FS, logs []string) {
+				corruptFile(t, fs, logs[1], 16)
+			},
+			wantErr: wal.ErrCorrupted,
+		},
+		{
+			name: "middle WAL/skip",
+			mode: WALRecoverySkipCorrupted,
+			damage: func(t *testing.T, fs vfs.FS, logs []string) {
+				corruptFile(t, fs, logs[1], 16)
+			},
+			check: func(t *testing.T, present []bool) {
+				// only the corrupted records are lost
+				if n, ok := prefixLen(present); ok || n == 0 || !present[walTestKeys-1] {
+					t.Fatalf("recovered %d keys before the first missing one, want everything but the corrupted records", n)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := vfs.NewMem()
+			tt.damage(t, fs, writeWALs(t, fs))
+
+			opts := &Options{FS: fs, WALBlockSize: 512, WALRecoveryMode: tt.mode}
+			d, err := Open("db", opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if d != nil {
+					d.Close()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, presentKeys(t, d))
+			if err = d.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// the recovered state must be stable, whatever recovery mode is used next
+			opts.WALRecoveryMode = WALRecoveryFail
+			if d, err = Open("db", opts); err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			tt.check(t, presentKeys(t, d))
+		})
+	}
+}
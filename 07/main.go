@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/cloudcentricdev/golang-tutorials/07/cli"
 	"github.com/cloudcentricdev/golang-tutorials/07/db"
@@ -25,11 +28,16 @@ func main() {
 		eraseDataFolder()
 	}
 
-	d, err := db.Open(dataFolder)
+	d, err := db.Open(dataFolder, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// the signal handler is installed first, so that an interrupted seeding still closes the database
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go closeOnSignal(d, signals)
+
 	if *shouldSeed {
 		seedDatabaseWithTestRecords(d)
 	}
@@ -37,6 +45,19 @@ func main() {
 	scanner := bufio.NewScanner(os.Stdin)
 	demo := cli.NewCLI(scanner, d)
 	demo.Start()
+
+	if err = d.Close(); err != nil && !errors.Is(err, db.ErrClosed) {
+		log.Fatal(err)
+	}
+}
+
+// closeOnSignal shuts the database down in an orderly fashion when the process is interrupted or terminated.
+func closeOnSignal(d *db.DB, signals <-chan os.Signal) {
+	<-signals
+	if err := d.Close(); err != nil && !errors.Is(err, db.ErrClosed) {
+		log.Fatal(err)
+	}
+	os.Exit(0)
 }
 
 func setupFlags() {
@@ -62,6 +83,9 @@ func seedDatabaseWithTestRecords(d *db.DB) {
 		k := []byte(faker.Word() + faker.Word())
 		v := []byte(faker.Word() + faker.Word())
 		err := d.Set(k, v)
+		if errors.Is(err, db.ErrClosed) {
+			return // closed by closeOnSignal, which exits the process
+		}
 		if err != nil {
 			log.Fatal(err)
 		}